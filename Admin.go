@@ -0,0 +1,285 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "time"
+)
+
+/* How long /silence lasts if no duration is given */
+const defaultSilence time.Duration = time.Hour
+
+/* The longest anyone but an admin can silence a pool for, so alerts can't
+   be turned off for good */
+const maxSilence time.Duration = time.Hour * 24
+
+/* /refresh - fetch the pools json and every pools height right now,
+   instead of waiting for the next cycle */
+func refreshCommand(c *CommandContext, args []string) {
+    /* Checking every pool takes longer than discord waits for an answer */
+    c.deferReply(true)
+
+    changes, err := updatePools(false)
+
+    if err != nil {
+        c.replyPrivate(fmt.Sprintf("Failed to update the pools list! " +
+                                   "Error: %s", err))
+        return
+    }
+
+    announcePoolChanges(c.session, changes)
+
+    checkForStuckChain(c.session)
+    checkForPoolsWithIssues(c.session)
+
+    publishSnapshot()
+    eventStream.publish()
+
+    c.replyPrivate(fmt.Sprintf("Refreshed %d pools. Median pool height: %d",
+                               len(globalInfo.pools), globalInfo.modeHeight))
+}
+
+/* Reloads the pools list after the overrides have been changed, so the
+   change takes effect straight away */
+func applyPoolOverrides(c *CommandContext, done string) {
+    /* Checking the new pools can take longer than discord waits */
+    c.deferReply(true)
+
+    /* The change is saved, so use the cached lists rather than failing if
+       they can't be downloaded */
+    changes, err := updatePools(true)
+
+    if err != nil {
+        c.replyPrivate(fmt.Sprintf("Saved, but failed to update the pools " +
+                                   "list! Error: %s", err))
+        return
+    }
+
+    announcePoolChanges(c.session, changes)
+
+    publishSnapshot()
+    eventStream.publish()
+
+    c.replyPrivate(done)
+}
+
+/* /pooladd <url> <api> <type> - start checking a pool that isn't in the
+   pools list */
+func poolAddCommand(c *CommandContext, args []string) {
+    url := trimPoolURL(args[0])
+    poolType := args[2]
+
+    if findPool(url) != nil {
+        c.replyPrivate(fmt.Sprintf("%s is already in the pools list! Use " +
+                                   "`%spooledit` to change it.", url,
+                                   commandPrefix()))
+        return
+    }
+
+    api, err := normalizeApi(args[1])
+
+    if err != nil {
+        c.replyPrivate(fmt.Sprintf("Invalid api %s! Error: %s", args[1], err))
+        return
+    }
+
+    if !knownPoolType(poolType) {
+        c.replyPrivate(fmt.Sprintf("Unknown type %s! It should be forknote " +
+                                   "or node.js.", poolType))
+        return
+    }
+
+    err = editPoolOverride(url, func(o *PoolOverride) bool {
+        o.Api = api
+        o.Type = poolType
+        o.Exclude = false
+        return true
+    })
+
+    if err != nil {
+        c.replyPrivate(fmt.Sprintf("Failed to save the pool overrides! " +
+                                   "Error: %s", err))
+        return
+    }
+
+    applyPoolOverrides(c, fmt.Sprintf("Added %s.", url))
+}
+
+/* /poolremove <pool> - stop checking a pool */
+func poolRemoveCommand(c *CommandContext, args []string) {
+    v := resolvePool(c, args[0])
+
+    if v == nil {
+        return
+    }
+
+    url := v.url
+    onlyOverride := v.source == overridesSource
+
+    err := editPoolOverride(url, func(o *PoolOverride) bool {
+        /* We added it, so just forget about it */
+        if onlyOverride {
+            return false
+        }
+
+        *o = PoolOverride{Url: o.Url, Exclude: true}
+        return true
+    })
+
+    if err != nil {
+        c.replyPrivate(fmt.Sprintf("Failed to save the pool overrides! " +
+                                   "Error: %s", err))
+        return
+    }
+
+    applyPoolOverrides(c, fmt.Sprintf("Removed %s.", url))
+}
+
+/* /pooledit <pool> api=<url> [type=<type>] - fix a pool in the pools
+   list */
+func poolEditCommand(c *CommandContext, args []string) {
+    v := resolvePool(c, args[0])
+
+    if v == nil {
+        return
+    }
+
+    url := v.url
+
+    var api, poolType string
+
+    for _, arg := range args[1:] {
+        parts := strings.SplitN(arg, "=", 2)
+
+        if len(parts) != 2 {
+            c.replyPrivate(fmt.Sprintf("Expected api=<url> or " +
+                                       "type=<type>, got %s!", arg))
+            return
+        }
+
+        switch strings.ToLower(parts[0]) {
+        case "api":
+            normalized, err := normalizeApi(parts[1])
+
+            if err != nil {
+                c.replyPrivate(fmt.Sprintf("Invalid api %s! Error: %s",
+                                           parts[1], err))
+                return
+            }
+
+            api = normalized
+        case "type":
+            if !knownPoolType(parts[1]) {
+                c.replyPrivate(fmt.Sprintf("Unknown type %s! It should be " +
+                                           "forknote or node.js.", parts[1]))
+                return
+            }
+
+            poolType = parts[1]
+        default:
+            c.replyPrivate(fmt.Sprintf("Can't change %s! Only api and " +
+                                       "type can be changed.", parts[0]))
+            return
+        }
+    }
+
+    err := editPoolOverride(url, func(o *PoolOverride) bool {
+        if api != "" {
+            o.Api = api
+        }
+
+        if poolType != "" {
+            o.Type = poolType
+        }
+
+        return true
+    })
+
+    if err != nil {
+        c.replyPrivate(fmt.Sprintf("Failed to save the pool overrides! " +
+                                   "Error: %s", err))
+        return
+    }
+
+    applyPoolOverrides(c, fmt.Sprintf("Changed %s.", url))
+}
+
+/* Turns "all" or a pool name into the place we store the silence, or nil
+   if the pool couldn't be found */
+func silenceTarget(c *CommandContext, name string) (*time.Time, string) {
+    if strings.ToLower(name) == "all" {
+        if c.level < levelAdmin {
+            c.replyPrivate("Only admins can silence all pools!")
+            return nil, ""
+        }
+
+        return &globalInfo.silencedUntil, "all pools"
+    }
+
+    v := resolvePool(c, name)
+
+    if v == nil {
+        return nil, ""
+    }
+
+    if !canManagePool(c, v) {
+        c.replyPrivate(fmt.Sprintf("Only pool operators and verified " +
+                                   "operators of %s can silence it!", v.url))
+        return nil, ""
+    }
+
+    return &v.silencedUntil, v.url
+}
+
+/* /silence <pool|all> [duration] */
+func silenceCommand(c *CommandContext, args []string) {
+    duration := defaultSilence
+
+    if len(args) == 2 {
+        d, err := time.ParseDuration(args[1])
+
+        if err != nil || d <= 0 {
+            c.replyPrivate(fmt.Sprintf("%s isn't a valid duration - try " +
+                                       "something like 30m or 2h.", args[1]))
+            return
+        }
+
+        duration = d
+    }
+
+    if duration > maxSilence && c.level < levelAdmin {
+        c.replyPrivate(fmt.Sprintf("Only admins can silence alerts for " +
+                                   "longer than %d hours!",
+                                   int(maxSilence.Hours())))
+        return
+    }
+
+    until, name := silenceTarget(c, args[0])
+
+    if until == nil {
+        return
+    }
+
+    *until = now().Add(duration)
+
+    c.reply(fmt.Sprintf("Alerts for %s are silenced for %s.", name,
+                        duration))
+}
+
+/* /unsilence <pool|all> */
+func unsilenceCommand(c *CommandContext, args []string) {
+    until, name := silenceTarget(c, args[0])
+
+    if until == nil {
+        return
+    }
+
+    if now().After(*until) {
+        c.replyPrivate(fmt.Sprintf("Alerts for %s aren't silenced!", name))
+        return
+    }
+
+    *until = time.Time{}
+
+    c.reply(fmt.Sprintf("Alerts for %s are no longer silenced.", name))
+}
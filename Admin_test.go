@@ -0,0 +1,218 @@
+package main
+
+import (
+    "testing"
+    "time"
+    "github.com/bwmarrin/discordgo"
+)
+
+const testOperator string = "1004"
+const testOperatorRole string = "2002"
+
+func TestRefreshDefersAndWaitsForCycle(t *testing.T) {
+    chat := setupTest(t)
+
+    writePoolsList(t, "a.example", "b.example")
+    stubPoolApis(map[string]int{"a.example": 1000, "b.example": 1000})
+
+    c := &CommandContext{session: chat, channelID: botsChannel,
+                         userID: testAdmin, level: levelAdmin,
+                         interaction: testInteraction(testAdmin, botsChannel)}
+
+    /* As if a cycle were running */
+    poolsLock.Lock()
+
+    done := make(chan bool)
+
+    go func() {
+        runCommand(c, findCommand("refresh"), nil)
+        close(done)
+    }()
+
+    /* Discord is told we're working on it straight away */
+    messages := waitForMessages(t, chat, 1)
+
+    response := messages[0].response
+
+    if response == nil || response.Type !=
+       discordgo.InteractionResponseDeferredChannelMessageWithSource ||
+       response.Data.Flags != discordgo.MessageFlagsEphemeral {
+        t.Fatalf("Expected an ephemeral deferred response, got %+v",
+                 messages[0])
+    }
+
+    time.Sleep(time.Millisecond * 50)
+
+    if len(chat.messages()) != 1 || len(globalInfo.pools) != 0 {
+        t.Fatalf("Refreshed while a cycle was running")
+    }
+
+    poolsLock.Unlock()
+
+    <-done
+
+    messages = chat.messages()
+
+    if len(messages) != 2 || messages[1].followup == nil {
+        t.Fatalf("Expected a follow up, got %d messages", len(messages))
+    }
+
+    expectText(t, messages[1:],
+               "Refreshed 2 pools. Median pool height: 1000")
+}
+
+func TestPoolAddDefersAndWaitsForCycle(t *testing.T) {
+    chat := setupTest(t)
+
+    writePoolsList(t, "a.example")
+    stubPoolApis(map[string]int{"a.example": 1000, "b.example": 1000})
+
+    c := &CommandContext{session: chat, channelID: botsChannel,
+                         userID: testAdmin, level: levelAdmin,
+                         interaction: testInteraction(testAdmin, botsChannel)}
+
+    poolsLock.Lock()
+
+    done := make(chan bool)
+
+    go func() {
+        runCommand(c, findCommand("pooladd"),
+                   []string{"b.example", "https://b.example/api/",
+                            "forknote"})
+        close(done)
+    }()
+
+    messages := waitForMessages(t, chat, 1)
+
+    response := messages[0].response
+
+    if response == nil || response.Type !=
+       discordgo.InteractionResponseDeferredChannelMessageWithSource ||
+       response.Data.Flags != discordgo.MessageFlagsEphemeral {
+        t.Fatalf("Expected an ephemeral deferred response, got %+v",
+                 messages[0])
+    }
+
+    time.Sleep(time.Millisecond * 50)
+
+    if len(chat.messages()) != 1 || len(globalInfo.pools) != 0 {
+        t.Fatalf("Changed the pools while a cycle was running")
+    }
+
+    poolsLock.Unlock()
+
+    <-done
+
+    if findPool("b.example") == nil {
+        t.Errorf("b.example not added")
+    }
+
+    expectText(t, chat.messages(), "Added b.example.")
+}
+
+func TestReplyRetriesFailedResponse(t *testing.T) {
+    chat := setupTest(t)
+
+    c := &CommandContext{session: chat, channelID: botsChannel,
+                         userID: testUser,
+                         interaction: testInteraction(testUser, botsChannel)}
+
+    chat.respondErr = discordError(500, 0)
+
+    c.replyPrivate("first")
+
+    if c.responded {
+        t.Fatalf("Marked as responded after the response failed")
+    }
+
+    chat.respondErr = nil
+
+    c.replyPrivate("second")
+
+    messages := chat.messages()
+
+    if len(messages) != 1 || messages[0].response == nil {
+        t.Fatalf("Expected the second reply to be the response, got %+v",
+                 messages)
+    }
+
+    expectText(t, messages, "second")
+}
+
+func TestOperatorLevel(t *testing.T) {
+    chat := setupTest(t)
+
+    config.Permissions.Operator = []string{testOperatorRole}
+    chat.addMember(testGuild, testOperator, []string{testOperatorRole})
+
+    globalInfo.pools = []PoolInfo{testPool("a.example", 1000)}
+
+    replies := say(chat, testUser, botsChannel, "/silence a.example")
+    expectText(t, replies,
+               "You need to be a pool operator to use `/silence`!")
+
+    replies = say(chat, testOperator, botsChannel, "/silence a.example 2h")
+    expectText(t, replies, "Alerts for a.example are silenced for 2h0m0s.")
+
+    if !globalInfo.pools[0].silencedUntil.Equal(now().Add(time.Hour * 2)) {
+        t.Errorf("Silenced until %s", globalInfo.pools[0].silencedUntil)
+    }
+
+    replies = say(chat, testOperator, botsChannel, "/threshold a.example 10")
+    expectText(t, replies, "a.example will be alerted about when it is " +
+                           "more than 10 blocks from the median.")
+
+    /* Only admins can silence everything */
+    replies = say(chat, testOperator, botsChannel, "/silence all")
+    expectText(t, replies, "Only admins can silence all pools!")
+
+    replies = say(chat, testOperator, botsChannel, "/refresh")
+    expectText(t, replies, "You need to be an admin to use `/refresh`!")
+}
+
+/* Every command waits for a running cycle, as they all read the pools */
+func TestCommandsWaitForCycle(t *testing.T) {
+    chat := setupTest(t)
+
+    globalInfo.pools = []PoolInfo{testPool("a.example", 1000)}
+
+    c := &CommandContext{session: chat, channelID: poolsChannel,
+                         userID: testUser, level: levelPublic,
+                         interaction: testInteraction(testUser, poolsChannel)}
+
+    poolsLock.Lock()
+
+    done := make(chan bool)
+
+    go func() {
+        runCommand(c, findCommand("watch"), []string{"a.example"})
+        close(done)
+    }()
+
+    messages := waitForMessages(t, chat, 1)
+
+    response := messages[0].response
+
+    if response == nil || response.Type !=
+       discordgo.InteractionResponseDeferredChannelMessageWithSource ||
+       response.Data.Flags != discordgo.MessageFlagsEphemeral {
+        t.Fatalf("Expected an ephemeral deferred response, got %+v",
+                 messages[0])
+    }
+
+    time.Sleep(time.Millisecond * 50)
+
+    if len(globalInfo.pools[0].claimees) != 0 {
+        t.Fatalf("Watched while a cycle was running")
+    }
+
+    poolsLock.Unlock()
+
+    <-done
+
+    if !elem(testUser, globalInfo.pools[0].claimees) {
+        t.Errorf("Not watching after the cycle")
+    }
+
+    expectText(t, chat.messages()[1:], "You are watching a.example")
+}
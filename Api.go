@@ -0,0 +1,226 @@
+package main
+
+import (
+    "strings"
+    "sync"
+    "time"
+    "net/http"
+    "crypto/sha1"
+    "encoding/hex"
+    "encoding/json"
+)
+
+/* A pool, as served by /api/pools */
+type PoolJSON struct {
+    Url             string     `json:"url"`
+    Api             string     `json:"api"`
+    Type            string     `json:"type"`
+    Height          int        `json:"height"`
+    Status          string     `json:"status"`
+    /* How many blocks it is ahead of the median */
+    Deviation       int        `json:"deviation"`
+    LastFound       *time.Time `json:"lastFound,omitempty"`
+    /* When it went down or forked, if it has */
+    ProblemSince    *time.Time `json:"problemSince,omitempty"`
+}
+
+/* Served by /api/network */
+type NetworkJSON struct {
+    ModeHeight      int        `json:"modeHeight"`
+    /* When the median height last changed */
+    LastFound       *time.Time `json:"lastFound,omitempty"`
+    Stuck           bool       `json:"stuck"`
+    Pools           int        `json:"pools"`
+    PoolsOk         int        `json:"poolsOk"`
+}
+
+/* The same data /heights uses, copied after each cycle so the HTTP server
+   doesn't read it while the cycle is changing it */
+type ApiSnapshot struct {
+    sync.RWMutex
+    pools           []PoolJSON
+    network         NetworkJSON
+}
+
+var apiSnapshot ApiSnapshot
+
+func optionalTime(t time.Time) *time.Time {
+    if t.IsZero() {
+        return nil
+    }
+
+    return &t
+}
+
+func publishSnapshot() {
+    pools := make([]PoolJSON, 0)
+    ok := 0
+
+    for index, _ := range globalInfo.pools {
+        v := &globalInfo.pools[index]
+
+        pool := PoolJSON{Url: v.url, Api: v.api, Type: v.poolType,
+                         Height: v.height, Status: poolStatus(v),
+                         LastFound: optionalTime(v.timeLastFound)}
+
+        if v.height != 0 {
+            pool.Deviation = v.height - globalInfo.modeHeight
+        }
+
+        if pool.Status == "Ok" {
+            ok++
+        } else {
+            pool.ProblemSince = optionalTime(v.timeStuck)
+        }
+
+        pools = append(pools, pool)
+    }
+
+    apiSnapshot.Lock()
+    defer apiSnapshot.Unlock()
+
+    apiSnapshot.pools = pools
+    apiSnapshot.network = NetworkJSON {
+        ModeHeight: globalInfo.modeHeight,
+        LastFound: optionalTime(globalInfo.heightLastUpdated),
+        Stuck: globalInfo.warned,
+        Pools: len(pools),
+        PoolsOk: ok,
+    }
+}
+
+func allowCORS(w http.ResponseWriter) {
+    origin := config.Http.CorsOrigin
+
+    if origin == "" {
+        origin = "*"
+    }
+
+    w.Header().Set("Access-Control-Allow-Origin", origin)
+    w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+    w.Header().Set("Access-Control-Allow-Headers", "If-None-Match")
+    w.Header().Set("Access-Control-Expose-Headers", "ETag")
+}
+
+/* Sends the value as JSON, or a 304 if they already have it */
+func serveJSON(w http.ResponseWriter, r *http.Request, status int,
+               value interface{}) {
+    body, err := json.Marshal(value)
+
+    if err != nil {
+        logError("Failed to encode API response",
+                 Fields{"url": r.URL.Path, "error": err})
+        http.Error(w, "Internal error", http.StatusInternalServerError)
+        return
+    }
+
+    sum := sha1.Sum(body)
+    etag := "\"" + hex.EncodeToString(sum[:]) + "\""
+
+    w.Header().Set("ETag", etag)
+    w.Header().Set("Cache-Control", "no-cache")
+
+    if status == http.StatusOK && r.Header.Get("If-None-Match") == etag {
+        w.WriteHeader(http.StatusNotModified)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    w.Write(body)
+}
+
+/* Handles CORS preflights and non GET requests. Returns false if the
+   request has been answered */
+func apiRequest(w http.ResponseWriter, r *http.Request) bool {
+    allowCORS(w)
+
+    if r.Method == http.MethodOptions {
+        w.WriteHeader(http.StatusNoContent)
+        return false
+    }
+
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        serveJSON(w, r, http.StatusMethodNotAllowed,
+                  map[string]string{"error": "Only GET is supported"})
+        return false
+    }
+
+    return true
+}
+
+/* /api/pools, /api/pools/{name} and /api/pools/{name}/history */
+func poolsHandler(w http.ResponseWriter, r *http.Request) {
+    if !apiRequest(w, r) {
+        return
+    }
+
+    name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/pools"), "/")
+
+    if strings.HasSuffix(name, "/history") {
+        poolHistoryHandler(w, r, strings.TrimSuffix(name, "/history"))
+        return
+    }
+
+    apiSnapshot.RLock()
+    defer apiSnapshot.RUnlock()
+
+    if name == "" {
+        serveJSON(w, r, http.StatusOK, apiSnapshot.pools)
+        return
+    }
+
+    for _, pool := range apiSnapshot.pools {
+        if normalizePool(pool.Url) == normalizePool(name) {
+            serveJSON(w, r, http.StatusOK, pool)
+            return
+        }
+    }
+
+    serveJSON(w, r, http.StatusNotFound,
+              map[string]string{"error": "Unknown pool " + name})
+}
+
+func poolHistoryHandler(w http.ResponseWriter, r *http.Request,
+                        name string) {
+    apiSnapshot.RLock()
+
+    url := ""
+
+    for _, pool := range apiSnapshot.pools {
+        if normalizePool(pool.Url) == normalizePool(name) {
+            url = pool.Url
+        }
+    }
+
+    apiSnapshot.RUnlock()
+
+    if result, ok := history.pool(url); ok {
+        serveJSON(w, r, http.StatusOK, result)
+        return
+    }
+
+    serveJSON(w, r, http.StatusNotFound,
+              map[string]string{"error": "Unknown pool " + name})
+}
+
+/* /api/network */
+func networkHandler(w http.ResponseWriter, r *http.Request) {
+    if !apiRequest(w, r) {
+        return
+    }
+
+    apiSnapshot.RLock()
+    defer apiSnapshot.RUnlock()
+
+    serveJSON(w, r, http.StatusOK, apiSnapshot.network)
+}
+
+/* /api/incidents, newest first */
+func incidentsHandler(w http.ResponseWriter, r *http.Request) {
+    if !apiRequest(w, r) {
+        return
+    }
+
+    serveJSON(w, r, http.StatusOK, incidents.list())
+}
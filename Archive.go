@@ -0,0 +1,301 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sync"
+    "time"
+    "net/http"
+    "encoding/json"
+)
+
+type ArchiveConfig struct {
+    /* Record every pool API response to this file. Empty to not record */
+    File        string `json:"file"`
+    /* Moved to file.1 and so on once it gets this big. Defaults to 10 */
+    MaxSizeMB   int    `json:"maxSizeMB"`
+    /* How many old files to keep. Defaults to 5 */
+    MaxFiles    int    `json:"maxFiles"`
+}
+
+/* The pools as they were when a pass started */
+type ArchivePool struct {
+    Url         string `json:"url"`
+    Api         string `json:"api"`
+    Type        string `json:"type"`
+}
+
+/* One line of the archive. Each pass over the pools starts with an entry
+   listing them, followed by the responses we got */
+type ArchiveEntry struct {
+    Time        time.Time     `json:"time"`
+    Cycle       int           `json:"cycle"`
+
+    /* Only set on the entry starting a pass */
+    Pools       []ArchivePool `json:"pools,omitempty"`
+    /* Whether the alerts were checked after this pass, rather than it
+       being the refresh after downloading the pools list */
+    Alerts      bool          `json:"alerts,omitempty"`
+
+    /* Only set on responses */
+    Url         string        `json:"url,omitempty"`
+    Status      int           `json:"status,omitempty"`
+    Header      http.Header   `json:"header,omitempty"`
+    /* As the http client gave it to us, before getBody undoes any gzip or
+       deflate */
+    Body        []byte        `json:"body,omitempty"`
+    /* If we didn't get a response at all */
+    Error       string        `json:"error,omitempty"`
+    Kind        string        `json:"kind,omitempty"`
+}
+
+type Archive struct {
+    sync.Mutex
+    out         io.Writer
+}
+
+var archive Archive
+
+func (a *Archive) open(c ArchiveConfig) error {
+    a.Lock()
+    defer a.Unlock()
+
+    if c.File == "" {
+        a.out = nil
+        return nil
+    }
+
+    file, err := openRotatingFile(LogConfig{File: c.File,
+                                            MaxSizeMB: c.MaxSizeMB,
+                                            MaxFiles: c.MaxFiles})
+
+    if err != nil {
+        return err
+    }
+
+    a.out = file
+
+    return nil
+}
+
+/* Must be called with the archive locked */
+func (a *Archive) write(entry ArchiveEntry) {
+    body, err := json.Marshal(entry)
+
+    if err != nil {
+        logError("Failed to encode archive entry", Fields{"error": err})
+        return
+    }
+
+    if _, err := a.out.Write(append(body, '\n')); err != nil {
+        logError("Failed to write archive entry", Fields{"error": err})
+    }
+}
+
+func (a *Archive) startPass(alerts bool) {
+    a.Lock()
+    defer a.Unlock()
+
+    if a.out == nil {
+        return
+    }
+
+    pools := make([]ArchivePool, 0)
+
+    for _, p := range globalInfo.pools {
+        pools = append(pools, ArchivePool{Url: p.url, Api: p.api,
+                                          Type: p.poolType})
+    }
+
+    a.write(ArchiveEntry{Time: now(), Cycle: globalInfo.cycle, Pools: pools,
+                         Alerts: alerts})
+}
+
+func (a *Archive) recordResponse(apiURL string, resp *ApiResponse,
+                                 err error) {
+    a.Lock()
+    defer a.Unlock()
+
+    if a.out == nil {
+        return
+    }
+
+    entry := ArchiveEntry{Time: now(), Cycle: globalInfo.cycle, Url: apiURL}
+
+    if err != nil {
+        entry.Error = err.Error()
+        entry.Kind = fetchErrorKind(err)
+    } else {
+        entry.Status = resp.Status
+        entry.Header = resp.Header
+        entry.Body = resp.Body
+    }
+
+    a.write(entry)
+}
+
+/* A pass read back from an archive */
+type ArchivePass struct {
+    start       ArchiveEntry
+    responses   map[string][]ArchiveEntry
+}
+
+func readArchive(file string) ([]ArchivePass, error) {
+    f, err := os.Open(file)
+
+    if err != nil {
+        return nil, err
+    }
+
+    defer f.Close()
+
+    passes := make([]ArchivePass, 0)
+
+    scanner := bufio.NewScanner(f)
+
+    /* Some pools send a lot */
+    scanner.Buffer(make([]byte, 0, 64 * 1024), 16 << 20)
+
+    line := 0
+
+    for scanner.Scan() {
+        line++
+
+        var entry ArchiveEntry
+
+        if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+            return nil, fmt.Errorf("Line %d: %s", line, err)
+        }
+
+        /* Responses always have a url */
+        if entry.Url == "" {
+            passes = append(passes, ArchivePass{start: entry,
+                responses: make(map[string][]ArchiveEntry)})
+            continue
+        }
+
+        /* The file was rotated part way through a pass */
+        if len(passes) == 0 {
+            continue
+        }
+
+        pass := passes[len(passes) - 1]
+        pass.responses[entry.Url] = append(pass.responses[entry.Url], entry)
+    }
+
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+
+    if len(passes) == 0 {
+        return nil, errors.New("No passes in archive")
+    }
+
+    return passes, nil
+}
+
+/* Answers from the pass instead of asking the pools */
+func (pass *ArchivePass) fetch(apiURL string) (*ApiResponse, error) {
+    responses := pass.responses[apiURL]
+
+    if len(responses) == 0 {
+        return nil, fetchError("other", apiURL,
+                               errors.New("Not in the archive"))
+    }
+
+    entry := responses[0]
+    pass.responses[apiURL] = responses[1:]
+
+    if entry.Error != "" {
+        return nil, fetchError(entry.Kind, apiURL, errors.New(entry.Error))
+    }
+
+    return &ApiResponse{Status: entry.Status, Header: entry.Header,
+                        Body: entry.Body}, nil
+}
+
+/* Sets the pools to the ones in the pass, keeping what we know about the
+   ones we already had */
+func (pass *ArchivePass) usePools() {
+    pools := make([]PoolInfo, 0)
+
+    for _, archived := range pass.start.Pools {
+        p := PoolInfo{url: archived.Url, api: archived.Api,
+                      poolType: archived.Type}
+
+        keepPoolState(&p)
+
+        pools = append(pools, p)
+    }
+
+    globalInfo.pools = pools
+}
+
+/* Feeds an archive back through the parsers and the alerts, sending the
+   alerts to the chat rather than discord */
+func replay(chat *FakeChat, file string) error {
+    passes, err := readArchive(file)
+
+    if err != nil {
+        logError("Failed to read archive", Fields{"file": file, "error": err})
+        return err
+    }
+
+    /* Nothing we do here should end up on disk or in the status board */
+    incidents.file = ""
+    config.StatusBoard = false
+    archive.out = nil
+
+    fakeClock := newFakeClock(passes[0].start.Time)
+    clock = fakeClock
+
+    for i, _ := range passes {
+        pass := &passes[i]
+
+        fakeClock.advance(pass.start.Time.Sub(now()))
+
+        pass.usePools()
+
+        fetchApi = pass.fetch
+
+        if !pass.start.Alerts {
+            populateHeights()
+            updateModeHeight()
+            continue
+        }
+
+        globalInfo.cycle = pass.start.Cycle - 1
+
+        fmt.Printf("=== Cycle %d, %s ===\n\n", pass.start.Cycle,
+                   now().Format(time.RFC3339))
+
+        heightCycle(chat)
+
+        printHeights()
+    }
+
+    fetchApi = fetchApiLive
+
+    return nil
+}
+
+func replayMain(args []string) {
+    if len(args) != 1 {
+        fmt.Fprintln(os.Stderr, "Usage: replay <archive>")
+        os.Exit(2)
+    }
+
+    if err := setupOffline(); err != nil {
+        os.Exit(1)
+    }
+
+    chat := newFakeChat("replay")
+    chat.onSend = printFakeMessage
+
+    if err := replay(chat, args[0]); err != nil {
+        os.Exit(1)
+    }
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "testing"
+    "time"
+    "net/http"
+    "encoding/json"
+    "io/ioutil"
+)
+
+func writeArchive(t *testing.T, entries []ArchiveEntry) string {
+    var buf bytes.Buffer
+
+    for _, entry := range entries {
+        body, err := json.Marshal(entry)
+
+        if err != nil {
+            t.Fatalf("Failed to encode archive entry: %s", err)
+        }
+
+        buf.Write(append(body, '\n'))
+    }
+
+    file := "archive.jsonl"
+
+    if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+        t.Fatalf("Failed to write archive: %s", err)
+    }
+
+    return file
+}
+
+/* What a forknote pool at this height would have sent, as it came off the
+   wire */
+func archivedResponse(t *testing.T, when time.Time, url string, height int,
+                      encoding string) ArchiveEntry {
+    body, err := fakeEncode(fmt.Sprintf("{\"network\":{\"height\":%d}," +
+                                        "\"pool\":{\"lastBlockFound\":" +
+                                        "\"%d000\"}}", height, when.Unix()),
+                            encoding)
+
+    if err != nil {
+        t.Fatalf("Failed to encode body: %s", err)
+    }
+
+    header := http.Header{}
+
+    if encoding != "" {
+        header.Set("Content-Encoding", encoding)
+    }
+
+    return ArchiveEntry{Time: when, Url: "https://" + url + "/api/stats",
+                        Status: http.StatusOK, Header: header, Body: body}
+}
+
+/* The pools list being downloaded, then a pool timing out for four checks
+   and coming back */
+func archiveFixture(t *testing.T) []ArchiveEntry {
+    pools := []ArchivePool {
+        {Url: "a.example", Api: "https://a.example/api/", Type: "forknote"},
+        {Url: "b.example", Api: "https://b.example/api/", Type: "forknote"},
+        {Url: "c.example", Api: "https://c.example/api/", Type: "forknote"},
+    }
+
+    entries := []ArchiveEntry {
+        /* Left over from before the file was rotated */
+        archivedResponse(t, testStart, "a.example", 999, ""),
+    }
+
+    for cycle := 0; cycle < 7; cycle++ {
+        when := testStart.Add(poolRefreshRate * time.Duration(cycle))
+
+        entries = append(entries, ArchiveEntry{Time: when, Cycle: cycle,
+                                               Pools: pools,
+                                               Alerts: cycle != 0})
+
+        /* Compressed the way some pools send it */
+        entries = append(entries,
+                         archivedResponse(t, when, "a.example", 1000, ""),
+                         archivedResponse(t, when, "b.example", 1000,
+                                          "gzip"))
+
+        if cycle >= 1 && cycle <= 4 {
+            entries = append(entries, ArchiveEntry {
+                Time: when, Url: "https://c.example/api/stats",
+                Error: "Client.Timeout exceeded", Kind: "timeout",
+            })
+        } else {
+            entries = append(entries,
+                             archivedResponse(t, when, "c.example", 1000,
+                                              "deflate"))
+        }
+    }
+
+    return entries
+}
+
+func TestReplay(t *testing.T) {
+    chat := setupTest(t)
+
+    file := writeArchive(t, archiveFixture(t))
+
+    if err := replay(chat, file); err != nil {
+        t.Fatalf("Failed to replay: %s", err)
+    }
+
+    messages := sentTo(chat.messages(), poolsChannel)
+
+    if len(messages) != 2 {
+        t.Fatalf("Expected a down and a recovered alert, got:\n%s",
+                 allText(messages))
+    }
+
+    /* The fourth failure, on the fifth cycle */
+    expectText(t, messages[:1], "Block Last Found: 2 minutes ago")
+    expectText(t, messages[:1], "*c.example                        0" +
+                                "          Api Down   2 minutes ago")
+    expectText(t, messages[1:], "*c.example                        1000" +
+                                "       Recovered  0 minutes ago")
+    expectNoText(t, messages, "a.example")
+    expectNoText(t, messages, "b.example")
+
+    if !now().Equal(testStart.Add(poolRefreshRate * 6)) {
+        t.Errorf("Clock at %s after replaying", now())
+    }
+
+    for _, v := range globalInfo.pools {
+        if v.height != 1000 {
+            t.Errorf("%s at %d after replaying", v.url, v.height)
+        }
+    }
+
+    /* Nothing recorded while replaying */
+    if archive.out != nil || incidents.file != "" {
+        t.Errorf("Replaying would write to disk")
+    }
+}
+
+/* What the bot records can be replayed into the same alerts */
+func TestReplayRecording(t *testing.T) {
+    chat := setupTest(t)
+
+    globalInfo.pools = []PoolInfo{testPool("a.example", 0),
+                                  testPool("b.example", 0),
+                                  testPool("c.example", 0)}
+
+    var recorded bytes.Buffer
+    archive.out = &recorded
+
+    heights := map[string]int{"a.example": 1000, "b.example": 1000,
+                              "c.example": 1000}
+
+    for cycle := 0; cycle < 6; cycle++ {
+        /* c.example goes down for a few checks */
+        if cycle == 1 {
+            delete(heights, "c.example")
+        } else if cycle == 5 {
+            heights["c.example"] = 1000
+        }
+
+        stubPoolApis(heights)
+
+        heightCycle(chat)
+
+        clock.(*FakeClock).advance(poolRefreshRate)
+    }
+
+    live := allText(chat.messages())
+
+    if live == "" {
+        t.Fatalf("Nothing alerted while recording")
+    }
+
+    replayed := setupTest(t)
+
+    if err := ioutil.WriteFile("archive.jsonl", recorded.Bytes(),
+                               0644); err != nil {
+        t.Fatalf("Failed to write archive: %s", err)
+    }
+
+    if err := replay(replayed, "archive.jsonl"); err != nil {
+        t.Fatalf("Failed to replay: %s", err)
+    }
+
+    if text := allText(replayed.messages()); text != live {
+        t.Errorf("Replayed:\n%s\n\nRecorded:\n%s", text, live)
+    }
+}
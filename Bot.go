@@ -9,8 +9,8 @@ import (
     "syscall"
     "bufio"
     "strings"
+    "sync"
     "net/http"
-    "encoding/json"
     "io/ioutil"
     "regexp"
     "strconv"
@@ -22,6 +22,7 @@ import (
     "crypto/tls"
 )
 
+/* The default pools list */
 const poolsJSON string = "https://raw.githubusercontent.com/turtlecoin/" +
                          "turtlecoin-pools-json/master/v2/turtlecoin-pools.json"
 
@@ -40,6 +41,21 @@ const poolMaxDifference int = 5
 /* How often we check the pools */
 const poolRefreshRate time.Duration = time.Second * 30
 
+/* How often we download the pools list */
+const poolUpdateRate time.Duration = time.Hour
+
+/* How soon we try again after failing to download it. This doubles each
+   time it fails */
+const poolRetryMin time.Duration = time.Minute
+
+/* How long downloading the pools list can take before we give up, so a
+   hung download doesn't stall the updates */
+const poolsJSONTimeout time.Duration = time.Second * 30
+
+/* How long a pool API can take to answer before we count it as down. The
+   tests shorten it, so a hung pool doesn't hold them up */
+var apiTimeout time.Duration = time.Second * 8
+
 /* Discord is limited to 2000 characters in a message */
 const messageLimit = 2000
 
@@ -52,10 +68,14 @@ type Pool struct {
     Url     string `json:"url"`
     Api     string `json:"api"`
     Type    string `json:"type"`
+    /* Which pools list it came from */
+    source  string
 }
 
 type Pools struct {
     Pools   []Pool `json:"pools"`
+    /* What was wrong with the entries we left out */
+    problems []PoolProblem
 }
 
 /* Info about every poo */
@@ -64,6 +84,15 @@ type PoolsInfo struct {
     modeHeight          int
     heightLastUpdated   time.Time
     warned              bool
+    /* Counts the heightWatcher cycles, so the logs of one can be found */
+    cycle               int
+    /* Don't send any alerts until then */
+    silencedUntil       time.Time
+    /* Claims on pools that have left the pools list, by pool */
+    dormantClaims       map[string]Watchers
+    /* The same for the email addresses and operators */
+    dormantEmails       map[string]EmailWatchers
+    dormantOperators    map[string]OperatorInfo
 }
 
 /* Info about an individual pool */
@@ -71,6 +100,12 @@ type PoolInfo struct {
     url                 string
     api                 string
     claimees            []string
+    /* The events each claimee wants to hear about. Missing means all of
+       them */
+    watchEvents         map[string][]string
+    emailees            []string
+    /* Who added each email address */
+    emailOwners         map[string]string
     height              int
     apiFailCounter      int
     warnedApi           bool
@@ -80,11 +115,52 @@ type PoolInfo struct {
     timeLastFound       time.Time
     timeStuck           time.Time
     poolType            string
+    /* Don't alert about this pool until then */
+    silencedUntil       time.Time
+    /* Users who have proved they run the pool */
+    operators           []string
+    /* Overrides poolMaxDifference, if set */
+    maxDifference       int
+    /* What went wrong with the pool most recently, so we know who to tell
+       when it recovers */
+    lastEvent           string
+    /* Which pools list it came from */
+    source              string
+}
+
+/* Who is watching a pool, as stored in claims.txt */
+type Watchers struct {
+    claimees            []string
+    events              map[string][]string
 }
 
 var globalInfo PoolsInfo
 
+/* Where we store who is watching each pool. The console points this and
+   the other state files at copies, so they can be changed freely */
+var claimsFile string = "claims.txt"
+
+/* Held by anything that reads or changes globalInfo - the cycles, the
+   pools list updates, and every command - so none of them see the pools
+   half changed. It isn't reentrant, so the command handlers mustn't take
+   it again */
+var poolsLock sync.Mutex
+
 func main() {
+    if len(os.Args) > 1 {
+        switch os.Args[1] {
+        case "replay":
+            replayMain(os.Args[2:])
+            return
+        case "check":
+            checkMain()
+            return
+        case "--console":
+            consoleMain()
+            return
+        }
+    }
+
     err := setup()
 
     if err != nil {
@@ -97,86 +173,89 @@ func main() {
         return
     }
 
-    fmt.Println("Bot started!")
+    logInfo("Bot started!", nil)
+
+    startHttpServer()
+
+    sdNotify("READY=1")
+    startWatchdog()
 
     /* Update the height and pools in the background */
-    go heightWatcher(discord)
-    go poolUpdater()
+    client := &DiscordClient{session: discord}
+
+    go heightWatcher(client)
+    go poolUpdater(client)
 
     sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
     <-sc
 
-    fmt.Println("Shutdown requested.")
+    logInfo("Shutdown requested.", nil)
+
+    sdNotify("STOPPING=1")
 
     discord.Close()
 
-    fmt.Println("Shutdown.")
+    logInfo("Shutdown.", nil)
 }
 
 func setup() error {
-    pools, err := getPools()
+    c, err := getConfig()
 
     if err != nil {
         return err
     }
 
-    claims, err := getClaims()
-
-    poolInfo := make([]PoolInfo, 0)
+    config = c
 
-    /* Populate each pool with their info */
-    for _, pool := range pools.Pools {
-        /* Skip non forknote and non nodejs pools */
-        if pool.Type != "forknote" && pool.Type != "node.js" {
-            continue
-        }
+    if err := setupLogger(config.Log); err != nil {
+        logError("Failed to setup logging", Fields{"error": err})
+        return err
+    }
 
-        var p PoolInfo
-        trimmed := pool.Url
+    if err := archive.open(config.Archive); err != nil {
+        logError("Failed to open archive",
+                 Fields{"file": config.Archive.File, "error": err})
+        return err
+    }
 
-        trimmed = strings.TrimPrefix(trimmed, "https://")
-        trimmed = strings.TrimPrefix(trimmed, "http://")
-        trimmed = strings.TrimSuffix(trimmed, "/")
+    extraNotifiers, err = makeNotifiers(config.Notifiers)
 
-        p.url = trimmed
-        p.api = pool.Api
-        p.poolType = pool.Type
+    if err != nil {
+        logError("Failed to setup notifiers", Fields{"error": err})
+        return err
+    }
 
-        /* Has the pool been claimed */
-        if val, ok := claims[p.url]; ok {
-            p.claimees = val
-        }
+    statusBoardMessages, err = getStatusBoard()
 
-        p.apiFailCounter = 0
+    if err != nil {
+        return err
+    }
 
-        p.warnedApi = false
-        p.warnedHeight = false
-        p.pinged = false
-        p.recovered = false
+    incidents.incidents, err = getIncidents()
 
-        poolInfo = append(poolInfo, p)
+    if err != nil {
+        return err
     }
 
-    /* Update the global struct */
-    globalInfo.pools = poolInfo
     globalInfo.warned = false
 
-    sort.Slice(globalInfo.pools, func(i, j int) bool {
-        return globalInfo.pools[i].url < globalInfo.pools[j].url
-    })
+    /* Start anyway, poolUpdater keeps trying */
+    if _, err := updatePools(true); err != nil {
+        logError("Starting without any pools", Fields{"error": err})
+    }
 
-    populateHeights()
-    updateModeHeight()
+    publishSnapshot()
 
     return nil
 }
 
 func writeClaims() {
-    file, err := os.Create("claims.txt")
+    file, err := os.Create(claimsFile)
 
     if err != nil {
-        fmt.Println("Failed to open file! Error:", err)
+        logError("Failed to open claims file",
+                 Fields{"file": claimsFile, "error": err})
         return
     }
 
@@ -184,19 +263,40 @@ func writeClaims() {
 
     for _, v := range globalInfo.pools {
         for _, owner := range v.claimees {
-            file.WriteString(fmt.Sprintf("%s:%s\n", v.url, owner))
+            line := fmt.Sprintf("%s:%s", v.url, owner)
+
+            /* Only watching some events */
+            if events, ok := v.watchEvents[owner]; ok {
+                line += ":" + strings.Join(events, ",")
+            }
+
+            file.WriteString(line + "\n")
+        }
+    }
+
+    for _, url := range dormantClaimURLs() {
+        watchers := globalInfo.dormantClaims[url]
+
+        for _, owner := range watchers.claimees {
+            line := fmt.Sprintf("%s:%s", url, owner)
+
+            if events, ok := watchers.events[owner]; ok {
+                line += ":" + strings.Join(events, ",")
+            }
+
+            file.WriteString(line + "\n")
         }
     }
 
     file.Sync()
 }
 
-func getClaims() (map[string][]string, error) {
-    claims := make(map[string][]string)
+func getClaims() (map[string]Watchers, error) {
+    claims := make(map[string]Watchers)
 
     /* File exists */
-    if _, err := os.Stat("claims.txt"); err == nil {
-        file, err := os.Open("claims.txt")
+    if _, err := os.Stat(claimsFile); err == nil {
+        file, err := os.Open(claimsFile)
 
         defer file.Close()
 
@@ -205,27 +305,38 @@ func getClaims() (map[string][]string, error) {
         }
 
         scanner := bufio.NewScanner(file)
-    
-        re := regexp.MustCompile("(.+):(\\d+)")
+
+        /* pool:user, or pool:user:events if they only want some events */
+        re := regexp.MustCompile("^(.+):(\\d+)(?::([a-z,]+))?$")
 
         for scanner.Scan() {
             matches := re.FindStringSubmatch(scanner.Text())
 
             if len(matches) < 3 {
-                fmt.Println("Failed to parse claim!")
+                logWarn("Failed to parse claim",
+                        Fields{"line": scanner.Text()})
                 continue
             }
 
-            /* Pool doesn't exist yet, create slice */
+            /* Pool doesn't exist yet, create it */
             if _, ok := claims[matches[1]]; !ok {
-                claims[matches[1]] = make([]string, 0)
+                claims[matches[1]] = Watchers{claimees: make([]string, 0),
+                                              events: make(map[string][]string)}
             }
 
-            claims[matches[1]] = append(claims[matches[1]], matches[2])
+            w := claims[matches[1]]
+
+            w.claimees = append(w.claimees, matches[2])
+
+            if matches[3] != "" {
+                w.events[matches[2]] = strings.Split(matches[3], ",")
+            }
+
+            claims[matches[1]] = w
         }
 
         if err := scanner.Err(); err != nil {
-            fmt.Println("Failed to read claims.txt! Error:", err)
+            logError("Failed to read claims.txt", Fields{"error": err})
             return claims, err
         }
     }
@@ -260,12 +371,19 @@ func deleteElem(needle string, haystack []string) []string {
     return haystack
 }
 
-func printStatus(s *discordgo.Session) {
-    printStatusFull(s, poolsChannel)
+func printStatus(s ChatClient) {
+    if now().Before(globalInfo.silencedUntil) {
+        return
+    }
+
+    /* The status board already shows every downed pool, so just post the
+       ones that changed */
+    printStatusFull(getNotifiers(s), config.StatusBoard)
 }
 
-func printStatusFull(s *discordgo.Session, channel string) {
+func printStatusFull(notifiers []Notifier, onlyChanged bool) {
     pingees := make([]string, 0)
+    emailees := make([]string, 0)
 
     lastFound := formatTime(globalInfo.heightLastUpdated)
 
@@ -274,15 +392,22 @@ func printStatusFull(s *discordgo.Session, channel string) {
         lastFound += " ago"
     }
 
-    msg := fmt.Sprintf("```Median pool height: %d\n" +
-                       "Block Last Found: %s\n\n" +
-                       "Currently Downed Pools            Height     " +
-                       "Status     Block Last Found     Time Stuck\n\n",
-                       globalInfo.modeHeight,
-                       lastFound)
+    header := fmt.Sprintf("Median pool height: %d\n" +
+                          "Block Last Found: %s\n",
+                          globalInfo.modeHeight,
+                          lastFound)
 
-    justDied := ""
-    alreadyDead := ""
+    title := "Currently Downed Pools"
+
+    if onlyChanged {
+        title = "Changed Pools"
+    }
+
+    msg := header + fmt.Sprintf("\n%-34sHeight     Status     " +
+                                "Block Last Found     Time Stuck\n\n", title)
+
+    justDied := make([]PoolRow, 0)
+    alreadyDead := make([]PoolRow, 0)
 
     for index, _ := range globalInfo.pools {
         v := &globalInfo.pools[index]
@@ -296,8 +421,7 @@ func printStatusFull(s *discordgo.Session, channel string) {
             }
 
             status = "Api Down"
-        } else if v.height > globalInfo.modeHeight + poolMaxDifference ||
-           v.height < globalInfo.modeHeight - poolMaxDifference {
+        } else if isForked(v) {
             status = "Forked"
         } else if v.recovered {
             status = "Recovered"
@@ -305,36 +429,39 @@ func printStatusFull(s *discordgo.Session, channel string) {
             continue
         }
 
-        newlyDowned := false
-
-        name := v.url
-
-        /* Highlight the pools that caused the list to change */
-        if v.recovered || !v.pinged {
-            name = fmt.Sprintf("*%s", v.url)
-            newlyDowned = true
-        }
-
         lastFound = formatTime(v.timeLastFound)
 
         if lastFound != "Never" {
             lastFound += " ago"
         }
 
-        addition := fmt.Sprintf("%-33s %-11d%-11s%-21s%s\n", name, v.height, 
-                                status, lastFound,
-                                formatTime(v.timeStuck))
+        row := PoolRow{url: v.url, height: v.height, status: status,
+                       lastFound: lastFound,
+                       timeStuck: formatTime(v.timeStuck),
+                       /* Highlight the pools that caused the list to
+                          change */
+                       changed: v.recovered || !v.pinged}
 
         /* Put the newly downed pools at the start of the message */
-        if newlyDowned {
-            justDied += addition
+        if row.changed {
+            justDied = append(justDied, row)
         } else {
-            alreadyDead += addition
+            alreadyDead = append(alreadyDead, row)
+        }
+
+        /* What they need to be watching to be pinged about this */
+        event := v.lastEvent
+
+        if status == "Api Down" {
+            event = eventApi
+        } else if status == "Forked" {
+            event = heightEvent(v)
         }
 
         for _, owner := range v.claimees {
             /* Ping on first fail, and recovery */
-            shouldPing := !v.pinged || v.recovered
+            shouldPing := (!v.pinged || v.recovered) &&
+                          wantsEvent(v, owner, event)
 
             /* Only ping once */
             if !elem(owner, pingees) && shouldPing {
@@ -342,20 +469,66 @@ func printStatusFull(s *discordgo.Session, channel string) {
             }
         }
 
+        for _, address := range v.emailees {
+            /* Same as the pings, so each address gets one email per cycle */
+            if !elem(address, emailees) && (!v.pinged || v.recovered) {
+                emailees = append(emailees, address)
+            }
+        }
+
         v.recovered = false
         v.pinged = true
     }
 
-    msg += justDied + alreadyDead + "```"
+    rows := justDied
+
+    if !onlyChanged {
+        rows = append(rows, alreadyDead...)
+    }
+
+    for _, row := range rows {
+        name := row.url
+
+        if row.changed {
+            name = fmt.Sprintf("*%s", row.url)
+        }
+
+        msg += fmt.Sprintf("%-33s %-11d%-11s%-21s%s\n", name, row.height,
+                           row.status, row.lastFound, row.timeStuck)
+    }
+
+    embeds := makePoolEmbeds(title, header, rows)
+
+    notifyAll(notifiers, Alert{text: msg, preformatted: true,
+                               pingees: pingees, emailees: emailees,
+                               embeds: embeds})
+}
 
-    for _, owner := range pingees {
-        /* Ping the owners */
-        msg += fmt.Sprintf("<@%s> ", owner)
+/* How far the pool can be from the others before we notify */
+func poolThreshold(v *PoolInfo) int {
+    /* The operator has asked for a different threshold */
+    if v.maxDifference > 0 {
+        return v.maxDifference
     }
 
-    s.ChannelMessageSend(channel, msg)
+    return poolMaxDifference
+}
 
-    return
+/* The status shown in /heights */
+func poolStatus(v *PoolInfo) string {
+    if v.height == 0 {
+        return "Api Down"
+    } else if isForked(v) {
+        return "Forked"
+    }
+
+    return "Ok"
+}
+
+/* Whether the pool is too far from the other pools heights */
+func isForked(v *PoolInfo) bool {
+    return v.height > globalInfo.modeHeight + poolThreshold(v) ||
+           v.height < globalInfo.modeHeight - poolThreshold(v)
 }
 
 func checkForApiIssues(v *PoolInfo) bool {
@@ -367,8 +540,10 @@ func checkForApiIssues(v *PoolInfo) bool {
         /* Only warn the user once */
         } else if !v.warnedApi {
             v.warnedApi = true
+            v.lastEvent = eventApi
             v.pinged = false
-            v.timeStuck = time.Now()
+            v.timeStuck = now()
+            incidents.start(v.url, eventApi)
             return true
         }
     } else {
@@ -378,6 +553,7 @@ func checkForApiIssues(v *PoolInfo) bool {
         if v.warnedApi {
             v.warnedApi = false
             v.recovered = true
+            incidents.end(v.url, eventApi)
             return true
         }
     }
@@ -388,12 +564,13 @@ func checkForApiIssues(v *PoolInfo) bool {
 func checkForHeightIssues(v *PoolInfo) bool {
     if v.height == 0 {
         return false
-    } else if v.height > globalInfo.modeHeight + poolMaxDifference ||
-              v.height < globalInfo.modeHeight - poolMaxDifference {
+    } else if isForked(v) {
         if !v.warnedHeight {
             v.warnedHeight = true
+            v.lastEvent = heightEvent(v)
             v.pinged = false
-            v.timeStuck = time.Now()
+            v.timeStuck = now()
+            incidents.start(v.url, v.lastEvent)
             return true
         }
     } else {
@@ -401,6 +578,7 @@ func checkForHeightIssues(v *PoolInfo) bool {
         if v.warnedHeight {
             v.warnedHeight = false
             v.recovered = true
+            incidents.end(v.url, eventFork, eventStale)
             return true
         }
     }
@@ -409,7 +587,7 @@ func checkForHeightIssues(v *PoolInfo) bool {
 }
 
 
-func checkForPoolsWithIssues(s *discordgo.Session) {
+func checkForPoolsWithIssues(s ChatClient) {
     newIssues := false
 
     for index, _ := range globalInfo.pools {
@@ -425,7 +603,8 @@ func checkForPoolsWithIssues(s *discordgo.Session) {
             }
         }
 
-        if ignore {
+        /* Silenced by an admin, skip it until the silence runs out */
+        if ignore || now().Before(v.silencedUntil) {
             continue
         }
 
@@ -447,407 +626,368 @@ func checkForPoolsWithIssues(s *discordgo.Session) {
     }
 }
 
-func checkForStuckChain(s *discordgo.Session) {
-    timeSinceLastBlock := time.Since(globalInfo.heightLastUpdated)
+func checkForStuckChain(s ChatClient) {
+    /* Silenced by an admin, we'll catch up once it runs out */
+    if now().Before(globalInfo.silencedUntil) {
+        return
+    }
+
+    /* Every pool has been down since we started, so there's no block to
+       measure from yet */
+    if globalInfo.heightLastUpdated.IsZero() {
+        return
+    }
+
+    timeSinceLastBlock := since(globalInfo.heightLastUpdated)
 
     /* Alert if the chain has been stuck for longer than 5 minutes */
     if timeSinceLastBlock > (time.Minute * 5) {
         /* Only warn once */
         if !globalInfo.warned {
-            s.ChannelMessageSend(poolsChannel,
-                                 fmt.Sprintf("```It looks like the chain is " +
-                                             "stuck! The last block was " +
-                                             "found %d minutes ago!```", 
-                                             int(timeSinceLastBlock.Minutes())))
+            msg := fmt.Sprintf("It looks like the chain is stuck! The " +
+                               "last block was found %d minutes ago!",
+                               int(timeSinceLastBlock.Minutes()))
+
+            embed := makeEmbed("🔴 Chain stuck", msg, colourApiDown)
+
+            notifyAll(getNotifiers(s),
+                      Alert{text: msg, preformatted: true,
+                            embeds: []*discordgo.MessageEmbed{embed}})
             globalInfo.warned = true
+            incidents.start("", incidentStuck)
         }
     /* We have already warned, so print out a recovery message */
     } else if globalInfo.warned {
         globalInfo.warned = false
-        s.ChannelMessageSend(poolsChannel,
-                             fmt.Sprintf("```The chain appears to have " +
-                                         "recovered. The last block was " +
-                                         "found %d minutes ago.```",
-                                         int(timeSinceLastBlock.Minutes())))
+        incidents.end("", incidentStuck)
+        msg := fmt.Sprintf("The chain appears to have recovered. The last " +
+                           "block was found %d minutes ago.",
+                           int(timeSinceLastBlock.Minutes()))
+
+        embed := makeEmbed("🟢 Chain recovered", msg, colourOk)
+
+        notifyAll(getNotifiers(s),
+                  Alert{text: msg, preformatted: true,
+                        embeds: []*discordgo.MessageEmbed{embed}})
     }
 }
 
-func heightWatcher(s *discordgo.Session) {
-    for {
-        time.Sleep(poolRefreshRate)
+func heightWatcher(s ChatClient) {
+    ticker := clock.NewTicker(poolRefreshRate)
+    defer ticker.Stop()
 
-        populateHeights()
-        updateModeHeight()
-
-        checkForStuckChain(s)
-        checkForPoolsWithIssues(s)
+    for range ticker.C() {
+        /* A bug in one cycle shouldn't stop the alerts for good */
+        recoverPanics("heightWatcher", func() {
+            heightCycle(s)
+        })
     }
 }
 
-/* Update the pools json every hour */
-func poolUpdater() {
-    for {
-        time.Sleep(time.Hour)
+/* Checks every pool once, and alerts about anything that has changed */
+func heightCycle(s ChatClient) {
+    poolsLock.Lock()
+    defer poolsLock.Unlock()
 
-        pools, err := getPools()
+    start := time.Now()
 
-        if err != nil {
-            fmt.Println("Failed to update pools info! Error:", err)
-            return
-        }
+    globalInfo.cycle++
 
-        claims, err := getClaims()
+    archive.startPass(true)
 
-        poolInfo := make([]PoolInfo, 0)
+    populateHeights()
+    updateModeHeight()
 
-        /* Populate each pool with their info */
-        for _, pool := range pools.Pools {
-            /* Skip non forknote and non nodejs pools */
-            if pool.Type != "forknote" && pool.Type != "node.js" {
-                continue
-            }
+    checkForStuckChain(s)
+    checkForPoolsWithIssues(s)
 
-            var p PoolInfo
-            trimmed := pool.Url
+    if config.StatusBoard {
+        updateStatusBoard(s)
+    }
 
-            trimmed = strings.TrimPrefix(trimmed, "https://")
-            trimmed = strings.TrimPrefix(trimmed, "http://")
-            trimmed = strings.TrimSuffix(trimmed, "/")
+    metrics.recordCycle(time.Since(start))
+    history.record()
+    publishSnapshot()
+    eventStream.publish()
 
-            p.url = trimmed
-            p.api = pool.Api
-            p.poolType = pool.Type
+    health.cycleFinished()
+}
 
-            /* Has the pool been claimed */
-            if val, ok := claims[p.url]; ok {
-                p.claimees = val
-            }
+/* Update the pools json every hour. If it fails, try again sooner, backing
+   off up to the hour */
+func poolUpdater(s ChatClient) {
+    wait := poolUpdateRate
 
-            p.apiFailCounter = 0
-
-            p.warnedApi = false
-            p.warnedHeight = false
-
-            /* Update it with the local pool info if it exists */
-            for _, localPool := range globalInfo.pools {
-                if p.url == localPool.url {
-                    p.apiFailCounter = localPool.apiFailCounter
-                    p.warnedApi = localPool.warnedApi
-                    p.warnedHeight = localPool.warnedHeight
-                    p.pinged = localPool.pinged
-                    p.recovered = localPool.recovered
-                    p.height = localPool.height
-                    p.timeLastFound = localPool.timeLastFound
-                    p.timeStuck = localPool.timeStuck
-                    break
-                }
+    /* We started without a list, don't wait an hour for one */
+    if len(globalInfo.pools) == 0 {
+        wait = poolRetryMin
+    }
+
+    for {
+        waitFor(wait)
+
+        var err error
+
+        recoverPanics("poolUpdater", func() {
+            poolsLock.Lock()
+            defer poolsLock.Unlock()
+
+            var changes PoolChanges
+
+            changes, err = updatePools(false)
+
+            if err == nil {
+                announcePoolChanges(s, changes)
             }
+        })
 
-            poolInfo = append(poolInfo, p)
+        if err == nil {
+            wait = poolUpdateRate
+            continue
         }
 
-        /* Update the global struct */
-        globalInfo.pools = poolInfo
+        if wait >= poolUpdateRate {
+            wait = poolRetryMin
+        } else {
+            wait *= 2
+        }
 
-        sort.Slice(globalInfo.pools, func(i, j int) bool {
-            return globalInfo.pools[i].url < globalInfo.pools[j].url
-        })
+        if wait > poolUpdateRate {
+            wait = poolUpdateRate
+        }
 
-        populateHeights()
-        updateModeHeight()
+        logWarn("Will try the pools list again", Fields{"in": wait})
     }
 }
 
-func formatTime(when time.Time) string {
-    mins := int(time.Since(when).Minutes())
-    hours := int(time.Since(when).Hours())
+/* Fetches the latest pools json, keeping what we know about the pools we
+   already had, and returns what changed. If useCache is set, the last good
+   copy is used for any list that can't be downloaded */
+func updatePools(useCache bool) (PoolChanges, error) {
+    pools, fresh, err := getPools(useCache)
 
-    if when.IsZero() {
-        return "Never"
-    } else if mins < 60 {
-        return fmt.Sprintf("%d minutes", mins);
-    } else if hours < 24 {
-        return fmt.Sprintf("%d hours", hours);
-    } else {
-        return fmt.Sprintf("%d days", int(hours / 24));
+    if err != nil {
+        logError("Failed to update pools info", Fields{"error": err})
+        return PoolChanges{}, err
     }
-}
 
-func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
-    /* Ignore our own messages */
-    if m.Author.ID == s.State.User.ID {
-        return
+    /* A cached list doesn't count as up to date */
+    if fresh {
+        health.poolsListUpdated()
     }
 
-    channel, err := s.Channel(m.ChannelID)
+    /* If we can't read who is watching, carry on with what we had, rather
+       than losing them the next time the files are written */
+    claims, err := getClaims()
 
     if err != nil {
-        fmt.Println("Failed to get channel! Error:", err)
-        return
+        logError("Failed to read claims", Fields{"error": err})
+        return PoolChanges{}, err
     }
 
-    member, err := s.GuildMember(channel.GuildID, m.Author.ID)
+    emails, err := getEmails()
 
     if err != nil {
-        fmt.Println("Failed to get guild member! Error:", err)
-        return
+        logError("Failed to read emails", Fields{"error": err})
+        return PoolChanges{}, err
     }
 
-    isColouredName := false
+    operators, err := getOperators()
 
-    for _, v := range member.Roles {
-        role, err := s.State.Role(channel.GuildID, v)
+    if err != nil {
+        return PoolChanges{}, err
+    }
 
-        if err != nil {
-            fmt.Println("Failed to get role! Error:", err)
-            return
-        }
+    poolInfo := make([]PoolInfo, 0)
 
-        if role.Name == "NINJA" || role.Name == "Developer" ||
-           role.Name == "helper" || role.Name == "FOOTCLAN" ||
-           role.Name == "Contributor" || role.Name == "PR Guerilla" ||
-           role.Name == "Service Operator" || role.Name == "Enforcer" ||
-           role.Name == "core" {
-            isColouredName = true
-            break
+    /* Populate each pool with their info */
+    for _, pool := range pools.Pools {
+        /* Skip non forknote and non nodejs pools */
+        if pool.Type != "forknote" && pool.Type != "node.js" {
+            continue
         }
-    }
 
-    /* Either in pools channel, bots channel, or coloured name to use */
-    if m.ChannelID != poolsChannel && m.ChannelID != botsChannel {
-        if !isColouredName {
-            return
+        var p PoolInfo
+
+        p.url = trimPoolURL(pool.Url)
+        p.api = pool.Api
+        p.poolType = pool.Type
+        p.source = pool.source
+
+        /* Has the pool been claimed */
+        if val, ok := claims[p.url]; ok {
+            p.claimees = val.claimees
+            p.watchEvents = val.events
         }
-    }
 
-    if m.Content == "/heights" || m.Content == "/status" {
-        lastFound := formatTime(globalInfo.heightLastUpdated)
+        if val, ok := emails[p.url]; ok {
+            p.emailees = val.addresses
+            p.emailOwners = val.owners
+        }
 
-        /* Never ago! */
-        if lastFound != "Never" {
-            lastFound += " ago"
+        if val, ok := operators[p.url]; ok {
+            p.operators = val.Operators
+            p.maxDifference = val.MaxDifference
         }
-        
-        heightsPretty := fmt.Sprintf("```Median pool height: %d\n" +
-                                     "Block Last Found: %s\n\n" +
-                                     "Pool                              " +
-                                     "Height     " +
-                                     "Status     Block Last Found\n\n",
-                                     globalInfo.modeHeight,
-                                     lastFound)
-
-        for _, v := range globalInfo.pools {
-
-            /* Message length will exceed discord limit, send what we have so
-               far then continue */
-            if len(heightsPretty) >= messageLimit - 200 {
-                heightsPretty += "```"
-                s.ChannelMessageSend(m.ChannelID, heightsPretty)
-                heightsPretty = "```"
-            }
 
-            status := "Ok"
+        p.apiFailCounter = 0
 
-            if v.height == 0 {
-                status = "Api Down"
-            } else if v.height > globalInfo.modeHeight + poolMaxDifference ||
-                      v.height < globalInfo.modeHeight - poolMaxDifference {
-                status = "Forked"
-            }
+        p.warnedApi = false
+        p.warnedHeight = false
 
-            lastFound = formatTime(v.timeLastFound)
+        keepPoolState(&p)
 
-            if lastFound != "Never" {
-                lastFound += " ago"
-            }
+        poolInfo = append(poolInfo, p)
+    }
 
-            heightsPretty += fmt.Sprintf("%-33s %-11d%-11s%s\n", v.url,
-                                         v.height, status,
-                                         lastFound)
-        }
+    changes := diffPools(globalInfo.pools, poolInfo)
 
-        heightsPretty += "```"
+    /* Update the global struct */
+    globalInfo.pools = poolInfo
+    globalInfo.dormantClaims = dormantClaims(claims, poolInfo)
+    globalInfo.dormantEmails = dormantEmails(emails, poolInfo)
+    globalInfo.dormantOperators = dormantOperators(operators, poolInfo)
 
-        s.ChannelMessageSend(m.ChannelID, heightsPretty)
+    sort.Slice(globalInfo.pools, func(i, j int) bool {
+        return globalInfo.pools[i].url < globalInfo.pools[j].url
+    })
 
-        return
-    }
+    archive.startPass(false)
 
-    if m.Content == "/help" {
-        helpCommand := fmt.Sprintf("```\nAvailable commands:\n\n" +
-                   "/help           Display this help message\n" +
-                   "/heights        Display the heights of all known pools\n" +
-                   "/status         An alias for /heights\n" +
-                   "/height         Display the median height of all pools\n" +
-                   "/height <pool>  Display the height of <pool>\n" +
-                   "/forked         Display any forked pools\n" +
-                   "/lastfound      Display the time since the last block was found\n" +
-                   "/watch <pool>   Watch the pool <pool> so you can be " +
-                                   "sent notifications\n" +
-                   "/unwatch <pool> Stop watching the pool <pool> so you no " +
-                                   "longer get sent notifications```")
+    populateHeights()
+    updateModeHeight()
 
-        s.ChannelMessageSend(m.ChannelID, helpCommand)
+    return changes, nil
+}
 
-        return
+/* Update it with the local pool info if it exists */
+func keepPoolState(p *PoolInfo) {
+    for _, localPool := range globalInfo.pools {
+        if p.url == localPool.url {
+            p.apiFailCounter = localPool.apiFailCounter
+            p.warnedApi = localPool.warnedApi
+            p.warnedHeight = localPool.warnedHeight
+            p.pinged = localPool.pinged
+            p.recovered = localPool.recovered
+            p.height = localPool.height
+            p.timeLastFound = localPool.timeLastFound
+            p.timeStuck = localPool.timeStuck
+            p.silencedUntil = localPool.silencedUntil
+            p.lastEvent = localPool.lastEvent
+            break
+        }
     }
+}
 
-    if m.Content == "/height" {
-        s.ChannelMessageSend(m.ChannelID, 
-                             fmt.Sprintf("```Median pool height: %d```", 
-                                         globalInfo.modeHeight))
+func formatTime(when time.Time) string {
+    mins := int(since(when).Minutes())
+    hours := int(since(when).Hours())
 
-        return
+    if when.IsZero() {
+        return "Never"
+    } else if mins < 60 {
+        return fmt.Sprintf("%d minutes", mins);
+    } else if hours < 24 {
+        return fmt.Sprintf("%d hours", hours);
+    } else {
+        return fmt.Sprintf("%d days", int(hours / 24));
     }
+}
 
-    if strings.HasPrefix(m.Content, "/height") {
-        message := strings.TrimPrefix(m.Content, "/height")
-        /* Remove first char - probably a space but should make sure */
-        message = message[1:]
+func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+    handleMessage(&DiscordClient{session: s}, m.Message)
+}
 
-        for _, v := range globalInfo.pools {
-            if v.url == message {
-                s.ChannelMessageSend(m.ChannelID,
-                                     fmt.Sprintf("```%s pool height:\n\n%d```",
-                                                 v.url, v.height))
-                return
-            }
-        }
+func handleMessage(s ChatClient, m *discordgo.Message) {
+    /* Ignore our own messages */
+    if m.Author.ID == s.BotUserID() {
+        return
+    }
 
-        s.ChannelMessageSend(m.ChannelID,
-                             fmt.Sprintf("Couldn't find pool %s - type " +
-                                         "`/heights` to view all known pools.",
-                                         message))
+    channel, err := s.Channel(m.ChannelID)
 
+    if err != nil {
+        logWarn("Failed to get channel",
+                Fields{"channel": m.ChannelID, "error": err})
         return
     }
 
-    if m.Content == "/watch" {
-        if m.ChannelID == poolsChannel {
-            s.ChannelMessageSend(m.ChannelID,
-                                 "You must specify a pool to watch!\nType " +
-                                 "`/heights` to list all pools.")
-
-        } else {
-            s.ChannelMessageSend(m.ChannelID,
-                                 "You can only use this command in the " +
-                                 "#stats channel!")
-        }
+    member, err := s.GuildMember(channel.GuildID, m.Author.ID)
 
+    if err != nil {
+        logWarn("Failed to get guild member",
+                Fields{"user": m.Author.ID, "error": err})
         return
     }
 
-    if strings.HasPrefix(m.Content, "/watch") {
-        if m.ChannelID == poolsChannel {
-            message := strings.TrimPrefix(m.Content, "/watch")
-            message = message[1:]
+    c := &CommandContext{session: s, channelID: m.ChannelID,
+                         guildID: channel.GuildID, userID: m.Author.ID,
+                         level: permissionLevel(s, channel.GuildID,
+                                                member.Roles)}
 
-            for index, _ := range globalInfo.pools {
-                v := &globalInfo.pools[index]
+    runTextCommand(c, m.Content)
+}
 
-                if v.url == message {
-                    if elem(m.Author.ID, v.claimees) {
-                        s.ChannelMessageSend(m.ChannelID,
-                                             fmt.Sprintf("You are already " +
-                                                         "watching %s!",
-                                                         v.url))
-                        return
-                    }
+/* The /heights table, split into as many messages as it needs */
+func heightsMessages() []*discordgo.MessageSend {
+    lastFound := formatTime(globalInfo.heightLastUpdated)
 
-                    v.claimees = append(v.claimees, m.Author.ID)
-                    
-                    s.ChannelMessageSend(m.ChannelID,
-                                         fmt.Sprintf("You are watching %s!", 
-                                                     v.url))
+    /* Never ago! */
+    if lastFound != "Never" {
+        lastFound += " ago"
+    }
 
-                    writeClaims()
+    rows := make([]PoolRow, 0)
 
-                    return
-                }
-            }
+    for _, v := range globalInfo.pools {
+        status := poolStatus(&v)
 
-            s.ChannelMessageSend(m.ChannelID,
-                                 fmt.Sprintf("Couldn't find pool %s - type " +
-                                             "`/heights` to view all known " +
-                                             "pools.", message))
-
-            return
-        } else {
-            s.ChannelMessageSend(m.ChannelID,
-                                 "You can only use this command in the " +
-                                 "#stats channel!")
+        poolLastFound := formatTime(v.timeLastFound)
 
+        if poolLastFound != "Never" {
+            poolLastFound += " ago"
         }
 
-        return
+        rows = append(rows, PoolRow{url: v.url, height: v.height,
+                                    status: status, lastFound: poolLastFound})
     }
 
-    if strings.HasPrefix(m.Content, "/unwatch") {
-        if m.ChannelID == poolsChannel {
-            message := strings.TrimPrefix(m.Content, "/unwatch")
-            message = message[1:]
-
-            for index, _ := range globalInfo.pools {
-                v := &globalInfo.pools[index]
-
-                if v.url == message {
-                    if elem(m.Author.ID, v.claimees) {
-                        s.ChannelMessageSend(m.ChannelID,
-                                             fmt.Sprintf("You are no longer" +
-                                                         "watching %s!",
-                                                         v.url))
+    if useEmbeds() {
+        description := fmt.Sprintf("Median pool height: %d\n" +
+                                   "Block Last Found: %s",
+                                   globalInfo.modeHeight, lastFound)
 
-                        v.claimees = deleteElem(m.Author.ID, v.claimees)
-
-                        writeClaims()
-
-                        return
-                    } else {
-                        s.ChannelMessageSend(m.ChannelID,
-                                             fmt.Sprintf("You are not " +
-                                                         "watching %s!",
-                                                         v.url))
-                        
-                        return
-                    }
-                }
-            }
+        return packEmbeds("", makePoolEmbeds("Pools", description, rows))
+    }
 
-            s.ChannelMessageSend(m.ChannelID,
-                                 fmt.Sprintf("Couldn't find pool %s - type " +
-                                             "`/heights` to view all known " +
-                                             "pools.", message))
+    messages := make([]*discordgo.MessageSend, 0)
 
-            return
+    heightsPretty := fmt.Sprintf("```Median pool height: %d\n" +
+                                 "Block Last Found: %s\n\n" +
+                                 "Pool                              " +
+                                 "Height     " +
+                                 "Status     Block Last Found\n\n",
+                                 globalInfo.modeHeight,
+                                 lastFound)
 
-        } else {
-            s.ChannelMessageSend(m.ChannelID,
-                                 "You can only use this command in the " +
-                                 "#stats channel!")
+    for _, row := range rows {
+        /* Message length will exceed discord limit, send what we have so
+           far then continue */
+        if len(heightsPretty) >= messageLimit - 200 {
+            heightsPretty += "```"
+            messages = append(messages,
+                              &discordgo.MessageSend{Content: heightsPretty})
+            heightsPretty = "```"
         }
 
-        return
+        heightsPretty += fmt.Sprintf("%-33s %-11d%-11s%s\n", row.url,
+                                     row.height, row.status,
+                                     row.lastFound)
     }
 
-    if m.Content == "/forked" {
-        printStatusFull(s, m.ChannelID)
-        return
-    }
-
-    if m.Content == "/lastfound" {
-        lastFound := formatTime(globalInfo.heightLastUpdated)
-
-        /* Never ago! */
-        if lastFound != "Never" {
-            lastFound += " ago"
-        }
-
-        s.ChannelMessageSend(m.ChannelID,
-                             fmt.Sprintf("```Block Last Found: %s```",
-                                         lastFound))
+    heightsPretty += "```"
 
-        return
-    }
+    return append(messages, &discordgo.MessageSend{Content: heightsPretty})
 }
 
 func getValues(heights map[string]int) []int {
@@ -869,9 +1009,14 @@ func updateModeHeight() {
 
     mode := mode(heights)
 
+    /* Every pool is down, so we can't tell what the height is */
+    if mode == 0 {
+        return
+    }
+
     if mode != globalInfo.modeHeight {
         globalInfo.modeHeight = mode
-        globalInfo.heightLastUpdated = time.Now()
+        globalInfo.heightLastUpdated = now()
     }
 }
 
@@ -898,6 +1043,10 @@ func mode(a []int) int {
         }
     }
 
+    if len(mode) == 0 {
+        return 0
+    }
+
     return mode[0]
 }
 
@@ -906,25 +1055,47 @@ func populateHeights() {
         /* Range takes a copy of the values, we need to directly access */
         v := &globalInfo.pools[index]
 
-        height, unix, err := getPoolHeightAndTimestamp(v)
+        start := time.Now()
+
+        var height int
+        var unix int64
+
+        /* Only left like this if it panics */
+        err := errors.New("Panicked while getting the height")
+
+        /* One pool sending something we didn't expect shouldn't stop us
+           checking the rest */
+        recoverPanics("populateHeights " + v.url, func() {
+            height, unix, err = getPoolHeightAndTimestamp(v)
+        })
+
+        metrics.recordFetch(v.url, time.Since(start), err)
 
         if err == nil {
             v.height = height
-            v.timeLastFound = time.Unix(unix, 0)
+            v.timeLastFound = time.Time{}
+
+            /* Zero if the pool has never found a block */
+            if unix != 0 {
+                v.timeLastFound = time.Unix(unix, 0)
+            }
         } else {
             v.height = 0
+
+            /* The only place a failed poll is logged */
+            logWarn("Failed to get pool height",
+                    Fields{"pool": v.url, "url": fetchErrorURL(err, v.api),
+                           "cycle": globalInfo.cycle,
+                           "kind": fetchErrorKind(err), "error": err})
         }
     }
 }
 
-func getBody (resp *http.Response, statsURL string) ([]byte, error) {
-    body, err := ioutil.ReadAll(resp.Body)
+/* Undoes whatever encoding the pool used */
+func getBody (resp *ApiResponse, statsURL string) ([]byte, error) {
+    body := resp.Body
 
-    if err != nil {
-        fmt.Printf("Failed to download stats from %s! Error: %s\n",
-                    statsURL, err)
-        return nil, err
-    }
+    var err error
 
     /* Some servers (Looking at you us.turtlepool.space!) send us deflate'd
        content even when we didn't ask for it - uncompress it */
@@ -932,8 +1103,8 @@ func getBody (resp *http.Response, statsURL string) ([]byte, error) {
         body, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(body)))
 
         if err != nil {
-            fmt.Println("Failed to deflate response from", statsURL)
-            return nil, err
+            return nil, fetchError("decode", statsURL,
+                                   fmt.Errorf("Failed to deflate: %s", err))
         }
     }
 
@@ -946,9 +1117,8 @@ func getBody (resp *http.Response, statsURL string) ([]byte, error) {
                     gz, err := gzip.NewReader(bytes.NewReader(body))
 
                     if err != nil {
-                        fmt.Println("Failed to ungzip response from %s! Error: %s\n",
-                                    statsURL, err)
-                        return nil, err
+                        err = fmt.Errorf("Failed to ungzip: %s", err)
+                        return nil, fetchError("decode", statsURL, err)
                     }
 
                     defer gz.Close()
@@ -956,9 +1126,8 @@ func getBody (resp *http.Response, statsURL string) ([]byte, error) {
                     body, err = ioutil.ReadAll(gz)
 
                     if err != nil {
-                        fmt.Println("Failed to ungzip response from %s! Error: %s\n",
-                                    statsURL, err)
-                        return nil, err
+                        err = fmt.Errorf("Failed to ungzip: %s", err)
+                        return nil, fetchError("decode", statsURL, err)
                     }
                     break
                 }
@@ -974,15 +1143,14 @@ func parseHeight(body string, statsURL string) (int, error) {
     height := heightRegex.FindStringSubmatch(body)
 
     if len(height) < 2 {
-        fmt.Println("Failed to parse height from", statsURL)
-        return 0, errors.New("Couldn't parse height")
+        return 0, fetchError("parse", statsURL,
+                             errors.New("Couldn't parse height"))
     }
 
     i, err := strconv.Atoi(height[1])
 
     if err != nil {
-        fmt.Println("Failed to convert height into int! Error:", err)
-        return 0, err
+        return 0, fetchError("parse", statsURL, err)
     }
 
     return i, nil
@@ -993,19 +1161,25 @@ func parseForknoteBody(body string, statsURL string) (int, int64, error) {
     blockFound := blockFoundRegex.FindStringSubmatch(body)
 
     if len(blockFound) < 2 {
-        fmt.Println("Failed to parse block last found timestamp from", statsURL)
-        return 0, 0, errors.New("Couldn't parse block timestamp")
+        return 0, 0, fetchError("parse", statsURL,
+                                errors.New("Couldn't parse block timestamp"))
     }
 
+    /* It's in milliseconds. Pools that have never found a block give
+       "0" */
     str := blockFound[1]
-    blockFound[1] = str[0:len(str) - 3]
-    
-    /* Don't overflow on 32 bit */
-    unix, err := strconv.ParseInt(blockFound[1], 10, 64)
 
-    if err != nil {
-        fmt.Println("Failed to convert timestamp into int! Error:", err)
-        return 0, 0, err
+    var unix int64
+
+    if len(str) > 3 {
+        var err error
+
+        /* Don't overflow on 32 bit */
+        unix, err = strconv.ParseInt(str[0:len(str) - 3], 10, 64)
+
+        if err != nil {
+            return 0, 0, fetchError("parse", statsURL, err)
+        }
     }
 
     i, err := parseHeight(body, statsURL)
@@ -1018,13 +1192,15 @@ func parseForknoteBody(body string, statsURL string) (int, int64, error) {
 }
 
 func parseForknote(p *PoolInfo) (int, int64, error) {
-    body, err := downloadApiLink(p.api + "stats")
+    statsURL := apiURL(p.api, "stats")
+
+    body, err := downloadApiLink(statsURL)
 
     if err != nil {
         return 0, 0, err
     }
 
-    height, unix, err := parseForknoteBody(body, p.api + "stats")
+    height, unix, err := parseForknoteBody(body, statsURL)
 
     if err != nil {
         return 0, 0, err
@@ -1033,26 +1209,58 @@ func parseForknote(p *PoolInfo) (int, int64, error) {
     return height, unix, nil
 }
 
-func downloadApiLink(apiURL string) (string, error) {
-    http.DefaultTransport.(*http.Transport).TLSClientConfig = 
-        &tls.Config{InsecureSkipVerify: true}
+/* A pool API response, as it came off the wire */
+type ApiResponse struct {
+    Status      int
+    Header      http.Header
+    Body        []byte
+}
+
+/* Where pool API responses come from. Replaced when replaying an
+   archive */
+var fetchApi func(apiURL string) (*ApiResponse, error) = fetchApiLive
 
-    timeout := time.Duration(8 * time.Second)
+/* Plenty of pools have broken certificates, so the pool APIs are fetched
+   without verifying them. Everything else uses the default, verified,
+   transport */
+var apiTransport = &http.Transport {
+    Proxy: http.ProxyFromEnvironment,
+    TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+}
 
+func fetchApiLive(apiURL string) (*ApiResponse, error) {
     client := http.Client {
-        Timeout: timeout,
+        Timeout: apiTimeout,
+        Transport: apiTransport,
     }
 
     resp, err := client.Get(apiURL)
 
     if err != nil {
-        fmt.Printf("Failed to download stats from %s! Error: %s\n", 
-                    apiURL, err)
-        return "", err
+        return nil, fetchError(connectionErrorKind(err), apiURL, err)
     }
 
     defer resp.Body.Close()
 
+    body, err := ioutil.ReadAll(resp.Body)
+
+    if err != nil {
+        return nil, fetchError(connectionErrorKind(err), apiURL, err)
+    }
+
+    return &ApiResponse{Status: resp.StatusCode, Header: resp.Header,
+                        Body: body}, nil
+}
+
+func downloadApiLink(apiURL string) (string, error) {
+    resp, err := fetchApi(apiURL)
+
+    archive.recordResponse(apiURL, resp, err)
+
+    if err != nil {
+        return "", err
+    }
+
     body, err := getBody(resp, apiURL)
 
     if err != nil {
@@ -1063,8 +1271,8 @@ func downloadApiLink(apiURL string) (string, error) {
 }
 
 func parseNodeJS(p *PoolInfo) (int, int64, error) {
-    networkURL := p.api + "network/stats"
-    poolURL := p.api + "pool/stats"
+    networkURL := apiURL(p.api, "network/stats")
+    poolURL := apiURL(p.api, "pool/stats")
 
     heightBody, err := downloadApiLink(networkURL)
 
@@ -1082,16 +1290,15 @@ func parseNodeJS(p *PoolInfo) (int, int64, error) {
     blockFound := blockFoundRegex.FindStringSubmatch(timeBody)
 
     if len(blockFound) < 2 {
-        fmt.Println("Failed to parse block last found timestamp from", poolURL)
-        return 0, 0, errors.New("Couldn't parse block timestamp")
+        return 0, 0, fetchError("parse", poolURL,
+                                errors.New("Couldn't parse block timestamp"))
     }
 
     /* Don't overflow on 32 bit */
     unix, err := strconv.ParseInt(blockFound[1], 10, 64)
 
     if err != nil {
-        fmt.Println("Failed to convert timestamp into int! Error:", err)
-        return 0, 0, err
+        return 0, 0, fetchError("parse", poolURL, err)
     }
 
     i, err := parseHeight(heightBody, networkURL)
@@ -1113,8 +1320,7 @@ func getPoolHeightAndTimestamp (p *PoolInfo) (int, int64, error) {
     } else if p.poolType == "node.js" {
         height, unix, err = parseNodeJS(p)
     } else {
-        fmt.Println("Unknown pool type", p.poolType, "skipping.")
-        return 0, 0, errors.New("Unknown pool type")
+        return 0, 0, errors.New("Unknown pool type " + p.poolType)
     }
 
     if err != nil {
@@ -1124,66 +1330,58 @@ func getPoolHeightAndTimestamp (p *PoolInfo) (int, int64, error) {
     return height, unix, nil
 }
 
-func getPools() (Pools, error) {
-    var pools Pools
-
-    resp, err := http.Get(poolsJSON)
-
-    if err != nil {
-        fmt.Println("Failed to download pools json! Error:", err)
-        return pools, err
-    }
-
-    defer resp.Body.Close()
-
-    body, err := ioutil.ReadAll(resp.Body)
-
-    if err != nil {
-        fmt.Println("Failed to download pools json! Error:", err)
-        return pools, err
-    }
-
-    if err := json.Unmarshal(body, &pools); err != nil {
-        fmt.Println("Failed to parse pools json! Error:", err)
-        return pools, err
-    }
-
-    return pools, nil
-}
-
 func startup() (*discordgo.Session, error) {
     var discord *discordgo.Session
 
     token, err := getToken()
 
     if err != nil {
-        fmt.Println("Failed to get token! Error:", err)
+        logError("Failed to get token", Fields{"error": err})
         return discord, err
     }
 
     discord, err = discordgo.New("Bot " + token)
 
     if err != nil {
-        fmt.Println("Failed to init bot! Error:", err)
+        logError("Failed to init bot", Fields{"error": err})
         return discord, err
     }
 
-    discord.AddHandler(messageCreate)
+    discord.AddHandler(interactionCreate)
+    discord.AddHandler(ready)
+    discord.AddHandler(gatewayConnected)
+    discord.AddHandler(gatewayDisconnected)
+
+    discord.Identify.Intents = discordgo.IntentsAllWithoutPrivileged
+
+    if textCommandsEnabled() {
+        discord.AddHandler(messageCreate)
+
+        /* Needed to read the text commands */
+        discord.Identify.Intents |= discordgo.IntentMessageContent
+    }
 
     err = discord.Open()
 
     if err != nil {
-        fmt.Println("Error opening connection! Error:", err)
+        logError("Failed to open connection", Fields{"error": err})
         return discord, err
     }
 
     discord.StateEnabled = true
 
-    fmt.Println("Connected to discord!")
+    logInfo("Connected to discord!", nil)
 
     return discord, nil
 }
 
+/* We need to know who we are before we can register the slash commands */
+func ready(s *discordgo.Session, r *discordgo.Ready) {
+    if err := registerSlashCommands(s); err != nil {
+        logError("Failed to register slash commands", Fields{"error": err})
+    }
+}
+
 func getToken() (string, error) {
     file, err := os.Open("token.txt")
 
@@ -0,0 +1,594 @@
+package main
+
+import (
+    "os"
+    "strings"
+    "testing"
+    "time"
+    "net/http"
+    "io/ioutil"
+)
+
+func TestParseForknoteBody(t *testing.T) {
+    height, unix, err := parseForknoteBody("{\"network\":{\"height\":1234}," +
+                                           "\"pool\":{\"lastBlockFound\":" +
+                                           "\"1577880000123\"}}", "stats")
+
+    if err != nil || height != 1234 || unix != 1577880000 {
+        t.Errorf("Got %d %d %v", height, unix, err)
+    }
+
+    /* A pool that has never found a block */
+    height, unix, err = parseForknoteBody("{\"network\":{\"height\":1234}," +
+                                          "\"pool\":{\"lastBlockFound\":" +
+                                          "\"0\"}}", "stats")
+
+    if err != nil || height != 1234 || unix != 0 {
+        t.Errorf("Got %d %d %v", height, unix, err)
+    }
+}
+
+func TestPopulateHeightsSurvivesPanics(t *testing.T) {
+    setupTest(t)
+
+    globalInfo.pools = []PoolInfo{testPool("a.example", 0),
+                                  testPool("bad.example", 0),
+                                  testPool("new.example", 0)}
+
+    stubPoolApis(map[string]int{"a.example": 1000})
+
+    stub := fetchApi
+
+    fetchApi = func(apiURL string) (*ApiResponse, error) {
+        switch apiURL {
+        case "https://bad.example/api/stats":
+            panic("bad pool")
+        case "https://new.example/api/stats":
+            body := "{\"network\":{\"height\":1000},\"pool\":" +
+                    "{\"lastBlockFound\":\"0\"}}"
+
+            return &ApiResponse{Status: http.StatusOK,
+                                Header: http.Header{},
+                                Body: []byte(body)}, nil
+        }
+
+        return stub(apiURL)
+    }
+
+    populateHeights()
+
+    a, bad, fresh := globalInfo.pools[0], globalInfo.pools[1],
+                     globalInfo.pools[2]
+
+    if a.height != 1000 || !a.timeLastFound.Equal(now()) {
+        t.Errorf("a.example: %d %s", a.height, a.timeLastFound)
+    }
+
+    if bad.height != 0 {
+        t.Errorf("bad.example should be down, got %d", bad.height)
+    }
+
+    if fresh.height != 1000 || !fresh.timeLastFound.IsZero() {
+        t.Errorf("new.example: %d %s", fresh.height, fresh.timeLastFound)
+    }
+
+    if formatTime(fresh.timeLastFound) != "Never" {
+        t.Errorf("Got %s", formatTime(fresh.timeLastFound))
+    }
+}
+
+/* A pool down, one stuck behind the median and one fine, with people
+   watching them */
+func setupStatusTest(t *testing.T) *FakeChat {
+    chat := setupTest(t)
+
+    globalInfo.modeHeight = 1000
+    globalInfo.heightLastUpdated = now().Add(-time.Minute * 2)
+
+    down := testPool("down.example", 0)
+    down.apiFailCounter = 3
+    down.timeLastFound = time.Time{}
+    down.claimees = []string{testUser}
+
+    stale := testPool("stale.example", 900)
+    stale.timeLastFound = now().Add(-time.Hour * 3)
+    stale.claimees = []string{testUser, testOtherUser}
+    /* Not interested in stale pools */
+    stale.watchEvents = map[string][]string{testOtherUser: {eventApi}}
+
+    fine := testPool("fine.example", 1000)
+    fine.claimees = []string{testOtherUser}
+
+    globalInfo.pools = []PoolInfo{down, stale, fine}
+
+    return chat
+}
+
+func TestPrintStatusFull(t *testing.T) {
+    chat := setupStatusTest(t)
+
+    checkForPoolsWithIssues(chat)
+
+    messages := sentTo(chat.messages(), poolsChannel)
+
+    if len(messages) != 1 {
+        t.Fatalf("Expected 1 alert, got %d", len(messages))
+    }
+
+    expected := "```Median pool height: 1000\n" +
+                "Block Last Found: 2 minutes ago\n" +
+                "\n" +
+                "Currently Downed Pools            Height     Status     " +
+                "Block Last Found     Time Stuck\n" +
+                "\n" +
+                "*down.example                     0          Api Down   " +
+                "Never                0 minutes\n" +
+                "*stale.example                    900        Forked     " +
+                "3 hours ago          0 minutes\n" +
+                "```<@1001> "
+
+    if messages[0].msg.Content != expected {
+        t.Errorf("Got:\n%q\nExpected:\n%q", messages[0].msg.Content,
+                 expected)
+    }
+
+    /* Nothing changed, so nothing is posted */
+    checkForPoolsWithIssues(chat)
+
+    if len(chat.messages()) != 1 {
+        t.Fatalf("Posted again without a change")
+    }
+
+    /* The API comes back */
+    clock.(*FakeClock).advance(time.Minute * 10)
+    globalInfo.heightLastUpdated = now()
+    globalInfo.pools[0].height = 1000
+
+    checkForPoolsWithIssues(chat)
+
+    messages = sentTo(chat.messages(), poolsChannel)
+
+    if len(messages) != 2 {
+        t.Fatalf("Expected a recovery alert, got %d messages",
+                 len(messages))
+    }
+
+    expected = "```Median pool height: 1000\n" +
+               "Block Last Found: 0 minutes ago\n" +
+               "\n" +
+               "Currently Downed Pools            Height     Status     " +
+               "Block Last Found     Time Stuck\n" +
+               "\n" +
+               "*down.example                     1000       Recovered  " +
+               "Never                10 minutes\n" +
+               "stale.example                     900        Forked     " +
+               "3 hours ago          10 minutes\n" +
+               "```<@1001> "
+
+    if messages[1].msg.Content != expected {
+        t.Errorf("Got:\n%q\nExpected:\n%q", messages[1].msg.Content,
+                 expected)
+    }
+}
+
+func TestPrintStatusPingsFiltered(t *testing.T) {
+    chat := setupStatusTest(t)
+
+    /* Only the stale pool, which testOtherUser doesn't want pings for */
+    globalInfo.pools = globalInfo.pools[1:]
+
+    checkForPoolsWithIssues(chat)
+
+    messages := sentTo(chat.messages(), poolsChannel)
+
+    if len(messages) != 1 {
+        t.Fatalf("Expected 1 alert, got %d", len(messages))
+    }
+
+    expectText(t, messages, "<@" + testUser + ">")
+    expectNoText(t, messages, "<@" + testOtherUser + ">")
+
+    /* Now the api is down too, which they do want */
+    globalInfo.pools[0].watchEvents[testOtherUser] = []string{eventStale}
+    globalInfo.pools[0].height = 0
+    globalInfo.pools[0].apiFailCounter = 3
+
+    checkForPoolsWithIssues(chat)
+
+    messages = sentTo(chat.messages(), poolsChannel)
+
+    if len(messages) != 2 {
+        t.Fatalf("Expected 2 alerts, got %d", len(messages))
+    }
+
+    if !strings.HasSuffix(messages[1].msg.Content, "<@" + testUser + "> ") {
+        t.Errorf("Expected only %s to be pinged: %q", testUser,
+                 messages[1].msg.Content)
+    }
+}
+
+func TestPrintStatusSilenced(t *testing.T) {
+    chat := setupStatusTest(t)
+
+    /* Only the down pool, silenced */
+    globalInfo.pools = globalInfo.pools[:1]
+    globalInfo.pools[0].silencedUntil = now().Add(time.Hour)
+
+    checkForPoolsWithIssues(chat)
+
+    if len(chat.messages()) != 0 {
+        t.Fatalf("Alerted about a silenced pool")
+    }
+
+    /* Caught up once the silence runs out */
+    clock.(*FakeClock).advance(time.Hour)
+
+    checkForPoolsWithIssues(chat)
+
+    expectText(t, sentTo(chat.messages(), poolsChannel), "*down.example")
+}
+
+func TestPrintStatusEmbeds(t *testing.T) {
+    chat := setupStatusTest(t)
+
+    config.DiscordFormat = discordFormatEmbed
+
+    checkForPoolsWithIssues(chat)
+
+    messages := sentTo(chat.messages(), poolsChannel)
+
+    if len(messages) != 1 || len(messages[0].msg.Embeds) == 0 {
+        t.Fatalf("Expected an embed alert, got %+v", messages)
+    }
+
+    if messages[0].msg.Content != "<@" + testUser + "> " {
+        t.Errorf("Expected the pings as the content, got %q",
+                 messages[0].msg.Content)
+    }
+
+    expectText(t, messages, "🔴 Api Down")
+    expectText(t, messages, "🟠 Forked")
+
+    for _, embed := range messages[0].msg.Embeds {
+        if embed.Timestamp != testStart.Format(time.RFC3339) {
+            t.Errorf("Embed stamped %s", embed.Timestamp)
+        }
+    }
+}
+
+func TestCheckForStuckChain(t *testing.T) {
+    chat := setupTest(t)
+
+    globalInfo.modeHeight = 1000
+    globalInfo.heightLastUpdated = now()
+
+    checkForStuckChain(chat)
+
+    if len(chat.messages()) != 0 {
+        t.Fatalf("Alerted about a chain that isn't stuck")
+    }
+
+    clock.(*FakeClock).advance(time.Minute * 6)
+
+    checkForStuckChain(chat)
+
+    messages := sentTo(chat.messages(), poolsChannel)
+
+    if len(messages) != 1 || messages[0].msg.Content !=
+       "```It looks like the chain is stuck! The last block was found 6 " +
+       "minutes ago!```" {
+        t.Fatalf("Expected a stuck alert, got %+v", messages)
+    }
+
+    /* Only once */
+    clock.(*FakeClock).advance(time.Minute)
+    checkForStuckChain(chat)
+
+    if len(chat.messages()) != 1 {
+        t.Fatalf("Alerted twice")
+    }
+
+    globalInfo.pools = []PoolInfo{testPool("a.example", 1001)}
+    updateModeHeight()
+
+    checkForStuckChain(chat)
+
+    messages = sentTo(chat.messages(), poolsChannel)
+
+    if len(messages) != 2 || messages[1].msg.Content !=
+       "```The chain appears to have recovered. The last block was found " +
+       "0 minutes ago.```" {
+        t.Fatalf("Expected a recovery, got %+v", messages)
+    }
+}
+
+func TestCheckForStuckChainSilenced(t *testing.T) {
+    chat := setupTest(t)
+
+    globalInfo.modeHeight = 1000
+    globalInfo.heightLastUpdated = now()
+    globalInfo.silencedUntil = now().Add(time.Hour)
+
+    clock.(*FakeClock).advance(time.Minute * 30)
+
+    checkForStuckChain(chat)
+
+    if len(chat.messages()) != 0 {
+        t.Fatalf("Alerted while silenced")
+    }
+
+    /* Catches up once the silence runs out */
+    clock.(*FakeClock).advance(time.Minute * 31)
+
+    checkForStuckChain(chat)
+
+    expectText(t, chat.messages(), "It looks like the chain is stuck!")
+}
+
+func TestCheckForStuckChainBeforeHeight(t *testing.T) {
+    chat := setupTest(t)
+
+    /* Every pool down since we started */
+    globalInfo.pools = []PoolInfo{testPool("a.example", 0)}
+    updateModeHeight()
+
+    checkForStuckChain(chat)
+
+    if len(chat.messages()) != 0 {
+        t.Fatalf("Alerted before seeing a height:\n%s",
+                 allText(chat.messages()))
+    }
+
+    /* The clock starts once a height is seen */
+    globalInfo.pools[0].height = 1000
+    updateModeHeight()
+
+    clock.(*FakeClock).advance(time.Minute * 6)
+
+    checkForStuckChain(chat)
+
+    expectText(t, chat.messages(), "The last block was found 6 minutes ago!")
+}
+
+func TestHandleMessage(t *testing.T) {
+    chat := setupTest(t)
+
+    globalInfo.modeHeight = 1000
+
+    /* Our own messages */
+    replies := say(chat, "bot", botsChannel, "/height")
+
+    if len(replies) != 0 {
+        t.Errorf("Replied to ourselves")
+    }
+
+    /* Not a command, or a command for another bot */
+    for _, content := range []string{"hello", "/", "/dance"} {
+        if replies := say(chat, testUser, botsChannel,
+                          content); len(replies) != 0 {
+            t.Errorf("Replied to %q", content)
+        }
+    }
+
+    /* Someone we don't know */
+    replies = say(chat, "9999", botsChannel, "/height")
+
+    if len(replies) != 0 {
+        t.Errorf("Replied to an unknown member")
+    }
+
+    replies = say(chat, testUser, botsChannel, "/height")
+
+    if len(replies) != 1 || replies[0].channelID != botsChannel ||
+       replies[0].msg.Content != "```Median pool height: 1000```" {
+        t.Errorf("Got %+v", replies)
+    }
+
+    /* The prefix can be changed */
+    config.CommandPrefix = "!"
+
+    replies = say(chat, testUser, botsChannel, "/height")
+
+    if len(replies) != 0 {
+        t.Errorf("Replied to the old prefix")
+    }
+
+    expectText(t, say(chat, testUser, botsChannel, "!HEIGHT"),
+               "Median pool height: 1000")
+}
+
+/* Starts the fake pools, with good.example and steady.example answering
+   at the median so the one under test can be compared against them */
+func startCycleTest(t *testing.T, steps []FakePoolStep) (*FakeChat,
+                                                         *FakePools) {
+    chat := setupTest(t)
+
+    apiTimeout = time.Millisecond * 200
+    t.Cleanup(func() { apiTimeout = time.Second * 8 })
+
+    good := []FakePoolStep{{Height: 1000}}
+
+    fakes := startFakePools([]FakePoolScript {
+        {Url: "good.example", Steps: good},
+        {Url: "steady.example", Type: "node.js", Steps: good},
+        {Url: "test.example", Steps: steps},
+    })
+
+    t.Cleanup(fakes.close)
+
+    globalInfo.pools = fakes.poolInfos()
+    globalInfo.pools[2].claimees = []string{testUser}
+
+    return chat, fakes
+}
+
+/* Runs a check, then moves the pools and the clock on to the next one */
+func runCycle(chat *FakeChat, fakes *FakePools) []FakeMessage {
+    before := len(chat.messages())
+
+    heightCycle(chat)
+
+    fakes.advance()
+    clock.(*FakeClock).advance(poolRefreshRate)
+
+    return chat.messages()[before:]
+}
+
+func TestHeightCycleEncodings(t *testing.T) {
+    for _, encoding := range []string{"gzip", "deflate", "gzip-multi"} {
+        chat, fakes := startCycleTest(t, []FakePoolStep {
+            {Height: 1000, Encoding: encoding},
+        })
+
+        if alerts := runCycle(chat, fakes); len(alerts) != 0 {
+            t.Errorf("%s: unexpected alerts:\n%s", encoding,
+                     allText(alerts))
+        }
+
+        v := globalInfo.pools[2]
+
+        if v.height != 1000 || v.apiFailCounter != 0 {
+            t.Errorf("%s: got height %d, %d failures", encoding, v.height,
+                     v.apiFailCounter)
+        }
+    }
+}
+
+func TestHeightCycleFailures(t *testing.T) {
+    for _, fail := range []string{"status", "hang", "garbage", "close"} {
+        chat, fakes := startCycleTest(t, []FakePoolStep {
+            {Fail: fail}, {Fail: fail}, {Fail: fail}, {Fail: fail},
+            {Height: 1000},
+        })
+
+        /* A blip or two isn't worth an alert */
+        for i := 0; i < 3; i++ {
+            if alerts := runCycle(chat, fakes); len(alerts) != 0 {
+                t.Fatalf("%s: alerted after %d failures:\n%s", fail, i + 1,
+                         allText(alerts))
+            }
+        }
+
+        alerts := runCycle(chat, fakes)
+
+        if len(alerts) != 1 {
+            t.Fatalf("%s: expected an alert, got %d", fail, len(alerts))
+        }
+
+        expectText(t, alerts, "*test.example                     0" +
+                              "          Api Down")
+        expectNoText(t, alerts, "good.example")
+        expectText(t, alerts, "<@" + testUser + ">")
+
+        alerts = runCycle(chat, fakes)
+
+        if len(alerts) != 1 {
+            t.Fatalf("%s: expected a recovery, got %d", fail, len(alerts))
+        }
+
+        expectText(t, alerts, "*test.example                     1000" +
+                              "       Recovered")
+        expectText(t, alerts, "<@" + testUser + ">")
+
+        if alerts := runCycle(chat, fakes); len(alerts) != 0 {
+            t.Errorf("%s: alerted after recovering:\n%s", fail,
+                     allText(alerts))
+        }
+    }
+}
+
+func TestHeightCycleForked(t *testing.T) {
+    chat, fakes := startCycleTest(t, []FakePoolStep {
+        {Height: 1000}, {Height: 900}, {Height: 1000},
+    })
+
+    if alerts := runCycle(chat, fakes); len(alerts) != 0 {
+        t.Fatalf("Unexpected alerts:\n%s", allText(alerts))
+    }
+
+    alerts := runCycle(chat, fakes)
+
+    expectText(t, alerts, "*test.example                     900" +
+                          "        Forked")
+
+    alerts = runCycle(chat, fakes)
+
+    expectText(t, alerts, "Recovered")
+}
+
+/* If who is watching can't be read, the pools are left as they were, so
+   the next write doesn't lose them */
+func TestUpdatePoolsKeepsWatchersOnReadError(t *testing.T) {
+    setupTest(t)
+
+    stubPoolApis(map[string]int{"a.example": 1000, "b.example": 1000})
+
+    writePoolsList(t, "a.example")
+
+    if _, err := updatePools(false); err != nil {
+        t.Fatalf("Failed to update pools: %s", err)
+    }
+
+    globalInfo.pools[0].emailees = []string{"someone@example.com"}
+
+    /* Opens, but can't be read */
+    if err := os.Mkdir(emailsFile, 0755); err != nil {
+        t.Fatalf("Failed to make %s: %s", emailsFile, err)
+    }
+
+    writePoolsList(t, "a.example", "b.example")
+
+    if _, err := updatePools(false); err == nil {
+        t.Errorf("Updated the pools without the emails")
+    }
+
+    if len(globalInfo.pools) != 1 ||
+       !elem("someone@example.com", globalInfo.pools[0].emailees) {
+        t.Errorf("Pools changed: %+v", globalInfo.pools)
+    }
+}
+
+func TestUpdatePoolsKeepsOperatorsOnParseError(t *testing.T) {
+    setupTest(t)
+
+    stubPoolApis(map[string]int{"a.example": 1000})
+
+    writePoolsList(t, "a.example")
+
+    if err := ioutil.WriteFile(operatorsFile, []byte("{\"a.example\":"),
+                               0644); err != nil {
+        t.Fatalf("Failed to write %s: %s", operatorsFile, err)
+    }
+
+    if _, err := updatePools(false); err == nil {
+        t.Errorf("Updated the pools without the operators")
+    }
+
+    if len(globalInfo.pools) != 0 {
+        t.Errorf("Pools changed: %+v", globalInfo.pools)
+    }
+}
+
+/* A refresh in the middle of an incident mustn't forget what went wrong,
+   or the recovery goes to the wrong watchers */
+func TestUpdatePoolsKeepsLastEvent(t *testing.T) {
+    setupTest(t)
+
+    stubPoolApis(map[string]int{})
+
+    writePoolsList(t, "a.example")
+
+    if _, err := updatePools(false); err != nil {
+        t.Fatalf("Failed to update pools: %s", err)
+    }
+
+    globalInfo.pools[0].lastEvent = eventApi
+
+    if _, err := updatePools(false); err != nil {
+        t.Fatalf("Failed to update pools: %s", err)
+    }
+
+    if event := globalInfo.pools[0].lastEvent; event != eventApi {
+        t.Errorf("Last event %q after a refresh", event)
+    }
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+    "github.com/bwmarrin/discordgo"
+)
+
+/* The parts of discord the alerts and commands use. Discord is one
+   implementation, FakeChat keeps everything in memory instead */
+type ChatClient interface {
+    /* Our own user ID, so we can ignore our own messages */
+    BotUserID() string
+    ChannelMessageSendComplex(channelID string,
+        msg *discordgo.MessageSend) (*discordgo.Message, error)
+    ChannelMessageEditComplex(
+        edit *discordgo.MessageEdit) (*discordgo.Message, error)
+    ChannelMessageDelete(channelID string, messageID string) error
+    ChannelMessagePin(channelID string, messageID string) error
+    Channel(channelID string) (*discordgo.Channel, error)
+    GuildMember(guildID string, userID string) (*discordgo.Member, error)
+    Role(guildID string, roleID string) (*discordgo.Role, error)
+    /* The channel for direct messages with the user */
+    UserChannelCreate(userID string) (*discordgo.Channel, error)
+    InteractionRespond(i *discordgo.Interaction,
+        response *discordgo.InteractionResponse) error
+    FollowupMessageCreate(i *discordgo.Interaction,
+        params *discordgo.WebhookParams) (*discordgo.Message, error)
+}
+
+/* The real thing */
+type DiscordClient struct {
+    session     *discordgo.Session
+}
+
+func (d *DiscordClient) BotUserID() string {
+    return d.session.State.User.ID
+}
+
+func (d *DiscordClient) ChannelMessageSendComplex(channelID string,
+        msg *discordgo.MessageSend) (*discordgo.Message, error) {
+    return d.session.ChannelMessageSendComplex(channelID, msg)
+}
+
+func (d *DiscordClient) ChannelMessageEditComplex(
+        edit *discordgo.MessageEdit) (*discordgo.Message, error) {
+    return d.session.ChannelMessageEditComplex(edit)
+}
+
+func (d *DiscordClient) ChannelMessageDelete(channelID string,
+        messageID string) error {
+    return d.session.ChannelMessageDelete(channelID, messageID)
+}
+
+func (d *DiscordClient) ChannelMessagePin(channelID string,
+        messageID string) error {
+    return d.session.ChannelMessagePin(channelID, messageID)
+}
+
+func (d *DiscordClient) Channel(channelID string) (*discordgo.Channel, error) {
+    return d.session.Channel(channelID)
+}
+
+func (d *DiscordClient) GuildMember(guildID string,
+        userID string) (*discordgo.Member, error) {
+    return d.session.GuildMember(guildID, userID)
+}
+
+func (d *DiscordClient) Role(guildID string,
+        roleID string) (*discordgo.Role, error) {
+    return d.session.State.Role(guildID, roleID)
+}
+
+func (d *DiscordClient) UserChannelCreate(
+        userID string) (*discordgo.Channel, error) {
+    return d.session.UserChannelCreate(userID)
+}
+
+func (d *DiscordClient) InteractionRespond(i *discordgo.Interaction,
+        response *discordgo.InteractionResponse) error {
+    return d.session.InteractionRespond(i, response)
+}
+
+/* Waits for the message, so we know its ID */
+func (d *DiscordClient) FollowupMessageCreate(i *discordgo.Interaction,
+        params *discordgo.WebhookParams) (*discordgo.Message, error) {
+    return d.session.FollowupMessageCreate(i, true, params)
+}
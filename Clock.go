@@ -0,0 +1,155 @@
+package main
+
+import (
+    "sort"
+    "sync"
+    "time"
+)
+
+/* Where the alerts get the time from, so a fake one can be swapped in to
+   run through hours of checks without waiting for them. Things measuring
+   how long something really took, like fetch latencies, use time directly */
+type Clock interface {
+    Now() time.Time
+    NewTicker(d time.Duration) Ticker
+}
+
+type Ticker interface {
+    C() <-chan time.Time
+    Stop()
+}
+
+var clock Clock = RealClock{}
+
+func now() time.Time {
+    return clock.Now()
+}
+
+func since(t time.Time) time.Duration {
+    return clock.Now().Sub(t)
+}
+
+/* Blocks until the clock has moved on by d */
+func waitFor(d time.Duration) {
+    ticker := clock.NewTicker(d)
+    defer ticker.Stop()
+
+    <-ticker.C()
+}
+
+type RealClock struct {}
+
+func (RealClock) Now() time.Time {
+    return time.Now()
+}
+
+func (RealClock) NewTicker(d time.Duration) Ticker {
+    return &RealTicker{ticker: time.NewTicker(d)}
+}
+
+type RealTicker struct {
+    ticker      *time.Ticker
+}
+
+func (t *RealTicker) C() <-chan time.Time {
+    return t.ticker.C
+}
+
+func (t *RealTicker) Stop() {
+    t.ticker.Stop()
+}
+
+/* A clock that only moves when told to */
+type FakeClock struct {
+    sync.Mutex
+    now         time.Time
+    tickers     []*FakeTicker
+}
+
+type FakeTicker struct {
+    clock       *FakeClock
+    period      time.Duration
+    next        time.Time
+    c           chan time.Time
+}
+
+func newFakeClock(start time.Time) *FakeClock {
+    return &FakeClock{now: start}
+}
+
+func (f *FakeClock) Now() time.Time {
+    f.Lock()
+    defer f.Unlock()
+
+    return f.now
+}
+
+func (f *FakeClock) NewTicker(d time.Duration) Ticker {
+    f.Lock()
+    defer f.Unlock()
+
+    /* Like time.Ticker, a tick is dropped if the last one hasn't been read */
+    t := &FakeTicker{clock: f, period: d, next: f.now.Add(d),
+                     c: make(chan time.Time, 1)}
+
+    f.tickers = append(f.tickers, t)
+
+    return t
+}
+
+/* Moves the clock on, firing any tickers that are due in the order they
+   would have fired */
+func (f *FakeClock) advance(d time.Duration) {
+    f.Lock()
+    defer f.Unlock()
+
+    end := f.now.Add(d)
+
+    for {
+        due := make([]*FakeTicker, 0)
+
+        for _, t := range f.tickers {
+            if !t.next.After(end) {
+                due = append(due, t)
+            }
+        }
+
+        if len(due) == 0 {
+            break
+        }
+
+        sort.Slice(due, func(i, j int) bool {
+            return due[i].next.Before(due[j].next)
+        })
+
+        t := due[0]
+
+        f.now = t.next
+        t.next = t.next.Add(t.period)
+
+        select {
+        case t.c <- f.now:
+        default:
+        }
+    }
+
+    f.now = end
+}
+
+func (t *FakeTicker) C() <-chan time.Time {
+    return t.c
+}
+
+func (t *FakeTicker) Stop() {
+    f := t.clock
+
+    f.Lock()
+    defer f.Unlock()
+
+    for i, v := range f.tickers {
+        if v == t {
+            f.tickers = append(f.tickers[:i], f.tickers[i + 1:]...)
+            break
+        }
+    }
+}
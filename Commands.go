@@ -0,0 +1,240 @@
+package main
+
+import (
+    "github.com/bwmarrin/discordgo"
+    "fmt"
+    "strings"
+    "time"
+)
+
+/* Who ran a command, and where to send the reply. Text commands reply in
+   the channel, slash commands reply to the interaction */
+type CommandContext struct {
+    session             ChatClient
+    channelID           string
+    guildID             string
+    userID              string
+    /* What the user is allowed to do */
+    level               PermissionLevel
+    /* Only set for slash commands */
+    interaction         *discordgo.Interaction
+    /* Whether we've already answered the interaction - later replies have
+       to be sent as follow ups */
+    responded           bool
+    /* The first public message we replied with, if we know it */
+    firstMessageID      string
+}
+
+func (c *CommandContext) reply(msg string) {
+    c.replyComplex(&discordgo.MessageSend{Content: msg}, false)
+}
+
+/* Only shown to the person who ran the command, if it was a slash command */
+func (c *CommandContext) replyPrivate(msg string) {
+    c.replyComplex(&discordgo.MessageSend{Content: msg}, true)
+}
+
+func (c *CommandContext) replyComplex(msg *discordgo.MessageSend,
+                                      private bool) {
+    var err error
+    var sent *discordgo.Message
+
+    if c.interaction == nil {
+        sent, err = c.session.ChannelMessageSendComplex(c.channelID, msg)
+    } else {
+        var flags discordgo.MessageFlags
+
+        if private {
+            flags = discordgo.MessageFlagsEphemeral
+        }
+
+        if !c.responded {
+            err = c.session.InteractionRespond(c.interaction,
+                &discordgo.InteractionResponse {
+                    Type: discordgo.InteractionResponseChannelMessageWithSource,
+                    Data: &discordgo.InteractionResponseData {
+                        Content: msg.Content,
+                        Embeds: msg.Embeds,
+                        AllowedMentions: msg.AllowedMentions,
+                        Flags: flags,
+                    },
+                })
+
+            /* If that failed, the next reply tries again */
+            c.responded = err == nil
+        } else {
+            sent, err = c.session.FollowupMessageCreate(c.interaction,
+                &discordgo.WebhookParams {
+                    Content: msg.Content,
+                    Embeds: msg.Embeds,
+                    AllowedMentions: msg.AllowedMentions,
+                    Flags: flags,
+                })
+        }
+    }
+
+    if err != nil {
+        logWarn("Failed to reply to command",
+                Fields{"channel": c.channelID, "user": c.userID,
+                       "error": err})
+        metrics.discordSendFailed()
+        return
+    }
+
+    if sent != nil && !private && c.firstMessageID == "" {
+        c.firstMessageID = sent.ID
+    }
+}
+
+/* Tells discord we're working on it, for commands that take longer than
+   the few seconds discord waits for an answer. The replies are sent as
+   follow ups. Text commands don't need this */
+func (c *CommandContext) deferReply(private bool) {
+    if c.interaction == nil || c.responded {
+        return
+    }
+
+    var flags discordgo.MessageFlags
+
+    if private {
+        flags = discordgo.MessageFlagsEphemeral
+    }
+
+    err := c.session.InteractionRespond(c.interaction,
+        &discordgo.InteractionResponse {
+            Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+            Data: &discordgo.InteractionResponseData {
+                Flags: flags,
+            },
+        })
+
+    if err != nil {
+        logWarn("Failed to defer reply to command",
+                Fields{"channel": c.channelID, "user": c.userID,
+                       "error": err})
+        metrics.discordSendFailed()
+        return
+    }
+
+    c.responded = true
+}
+
+/* Sends alerts as a reply to a command, e.g. for /forked */
+type ReplyNotifier struct {
+    context     *CommandContext
+}
+
+func (r *ReplyNotifier) Name() string {
+    return "reply"
+}
+
+func (r *ReplyNotifier) Notify(alert Alert) error {
+    for _, msg := range discordMessages(alert) {
+        r.context.replyComplex(msg, false)
+    }
+
+    return nil
+}
+
+func heightsCommand(c *CommandContext, args []string) {
+    for _, msg := range heightsMessages() {
+        c.replyComplex(msg, false)
+    }
+}
+
+/* /height, or /height <pool> */
+func heightCommand(c *CommandContext, args []string) {
+    if len(args) == 0 {
+        c.reply(fmt.Sprintf("```Median pool height: %d```",
+                            globalInfo.modeHeight))
+        return
+    }
+
+    v := resolvePool(c, args[0])
+
+    if v == nil {
+        return
+    }
+
+    c.reply(fmt.Sprintf("```%s pool height:\n\n%d```", v.url, v.height))
+}
+
+/* /pool <pool> */
+func poolCommand(c *CommandContext, args []string) {
+    v := resolvePool(c, args[0])
+
+    if v == nil {
+        return
+    }
+
+    lastFound := formatTime(v.timeLastFound)
+
+    if lastFound != "Never" {
+        lastFound += " ago"
+    }
+
+    msg := fmt.Sprintf("```%s\n\n" +
+                       "Height:            %d\n" +
+                       "Status:            %s\n" +
+                       "Block Last Found:  %s\n" +
+                       "Type:              %s\n" +
+                       "API:               %s\n" +
+                       "Source:            %s\n" +
+                       "Fork threshold:    %d blocks\n" +
+                       "Watchers:          %d\n",
+                       v.url, v.height, poolStatus(v), lastFound,
+                       v.poolType, v.api, v.source, poolThreshold(v),
+                       len(v.claimees) + len(v.emailees))
+
+    if now().Before(v.silencedUntil) {
+        msg += fmt.Sprintf("Silenced for:      %s\n",
+                           v.silencedUntil.Sub(now()).Round(time.Minute))
+    }
+
+    msg += "```"
+
+    if len(v.operators) != 0 {
+        operators := make([]string, 0)
+
+        for _, operator := range v.operators {
+            operators = append(operators, fmt.Sprintf("<@%s>", operator))
+        }
+
+        msg += "✅ Verified operators: " + strings.Join(operators, ", ")
+    } else {
+        msg += "No verified operators"
+    }
+
+    /* Don't ping the operators, just show them */
+    c.replyComplex(&discordgo.MessageSend {
+        Content: msg,
+        AllowedMentions: &discordgo.MessageAllowedMentions{},
+    }, false)
+}
+
+func forkedCommand(c *CommandContext, args []string) {
+    printStatusFull([]Notifier{&ReplyNotifier{context: c}}, false)
+}
+
+func lastFoundCommand(c *CommandContext, args []string) {
+    lastFound := formatTime(globalInfo.heightLastUpdated)
+
+    /* Never ago! */
+    if lastFound != "Never" {
+        lastFound += " ago"
+    }
+
+    c.reply(fmt.Sprintf("```Block Last Found: %s```", lastFound))
+}
+
+/* Watching is only allowed in the pools channel, so it doesn't get
+   spammed elsewhere */
+func inPoolsChannel(c *CommandContext) bool {
+    if c.channelID != poolsChannel {
+        c.replyPrivate("You can only use this command in the #stats " +
+                       "channel!")
+        return false
+    }
+
+    return true
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+/* Two pools that are fine, and one that's down */
+func setupCommandTest(t *testing.T) *FakeChat {
+    chat := setupTest(t)
+
+    globalInfo.modeHeight = 1000
+    globalInfo.heightLastUpdated = now().Add(-time.Minute * 2)
+
+    down := testPool("down.example", 0)
+    down.apiFailCounter = 3
+
+    globalInfo.pools = []PoolInfo{testPool("alpha.example", 1000),
+                                  testPool("beta.example", 1000), down}
+
+    return chat
+}
+
+/* Lets the test carry on without waiting for the buckets to fill */
+func resetRateLimits() {
+    rateLimiter.buckets = make(map[string]*TokenBucket)
+}
+
+func TestHelpCommand(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    replies := say(chat, testUser, botsChannel, "/help")
+    expectText(t, replies, "Available commands:")
+    expectText(t, replies, "/watch <pool...|all> [only <alerts>]")
+
+    /* Don't show them what they can't use */
+    expectNoText(t, replies, "/refresh")
+    expectNoText(t, replies, "/silence")
+
+    replies = say(chat, testAdmin, botsChannel, "/help")
+    expectText(t, replies, "/refresh")
+    expectText(t, replies, "/silence <pool|all> [duration]")
+
+    replies = say(chat, testUser, botsChannel, "/help watch")
+    expectText(t, replies, "/watch <pool...|all> [only <alerts>]\n\n" +
+                           "Get sent notifications about <pool>")
+
+    replies = say(chat, testUser, botsChannel, "/help heights")
+    expectText(t, replies, "Aliases: /status")
+
+    replies = say(chat, testUser, botsChannel, "/help silence")
+    expectText(t, replies, "Needs: pool operator, or a verified operator of " +
+                           "the pool")
+
+    replies = say(chat, testUser, botsChannel, "/help dance")
+    expectText(t, replies, "Unknown command dance - type `/help` to view " +
+                           "all commands.")
+}
+
+func TestHeightsCommand(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    for _, command := range []string{"/heights", "/status"} {
+        /* Not answered twice in a row */
+        rateLimiter.recent = make(map[string]RecentReply)
+
+        replies := say(chat, testUser, botsChannel, command)
+
+        expectText(t, replies, "alpha.example")
+        expectText(t, replies, "beta.example")
+        expectText(t, replies, "down.example")
+    }
+}
+
+func TestHeightCommand(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    replies := say(chat, testUser, botsChannel, "/height")
+
+    if allText(replies) != "Median pool height: 1000" {
+        t.Errorf("Got %q", allText(replies))
+    }
+
+    replies = say(chat, testUser, botsChannel, "/height alpha")
+
+    if len(replies) != 1 || replies[0].msg.Content !=
+       "```alpha.example pool height:\n\n1000```" {
+        t.Errorf("Got %+v", replies)
+    }
+
+    replies = say(chat, testUser, botsChannel, "/height example")
+    expectText(t, replies, "example matches more than one pool: ")
+    expectText(t, replies, "Which one did you mean?")
+
+    replies = say(chat, testUser, botsChannel, "/height alpah.example")
+    expectText(t, replies, "Couldn't find pool alpah.example - did you " +
+                           "mean alpha.example?")
+
+    replies = say(chat, testUser, botsChannel, "/height zzzzzzzz")
+    expectText(t, replies, "Couldn't find pool zzzzzzzz - type `/heights` " +
+                           "to view all known pools.")
+
+    replies = say(chat, testUser, botsChannel, "/height alpha beta")
+    expectText(t, replies, "Usage: `/height [pool]`")
+}
+
+func TestPoolCommand(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    globalInfo.pools[0].claimees = []string{testUser}
+    globalInfo.pools[0].silencedUntil = now().Add(time.Minute * 30)
+
+    replies := say(chat, testUser, botsChannel, "/pool alpha")
+
+    expectText(t, replies, "alpha.example\n\n" +
+                           "Height:            1000\n" +
+                           "Status:            Ok\n")
+    expectText(t, replies, "Watchers:          1\n" +
+                           "Silenced for:      30m0s\n")
+    expectText(t, replies, "No verified operators")
+
+    /* Shown, but not pinged */
+    globalInfo.pools[0].operators = []string{testUser}
+
+    replies = say(chat, testUser, botsChannel, "/pool alpha")
+    expectText(t, replies, "Verified operators: <@" + testUser + ">")
+
+    if mentions := replies[0].msg.AllowedMentions; mentions == nil ||
+       len(mentions.Users) != 0 || len(mentions.Parse) != 0 {
+        t.Errorf("Operators would be pinged: %+v", mentions)
+    }
+
+    replies = say(chat, testUser, botsChannel, "/pool")
+    expectText(t, replies, "Usage: `/pool <pool>`")
+}
+
+func TestForkedCommand(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    globalInfo.pools[2].claimees = []string{testUser}
+
+    /* They've already been told it's down */
+    checkForPoolsWithIssues(chat)
+
+    replies := say(chat, testUser, botsChannel, "/forked")
+
+    expectText(t, replies, "\ndown.example")
+    expectNoText(t, replies, "alpha.example")
+
+    /* Asking doesn't ping them again */
+    expectNoText(t, replies, "<@" + testUser + ">")
+}
+
+func TestLastFoundCommand(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    replies := say(chat, testUser, botsChannel, "/lastfound")
+
+    if len(replies) != 1 || replies[0].msg.Content !=
+       "```Block Last Found: 2 minutes ago```" {
+        t.Errorf("Got %+v", replies)
+    }
+
+    globalInfo.heightLastUpdated = time.Time{}
+
+    replies = say(chat, testUser, botsChannel, "/lastfound")
+    expectText(t, replies, "Block Last Found: Never")
+}
+
+func TestWatchCommands(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    replies := say(chat, testUser, botsChannel, "/watch alpha")
+    expectText(t, replies, "You can only use this command in the #stats " +
+                           "channel!")
+
+    replies = say(chat, testUser, poolsChannel, "/mywatches")
+    expectText(t, replies, "You aren't watching any pools - type `/watch")
+
+    replies = say(chat, testUser, poolsChannel, "/watch alpha")
+    expectText(t, replies, "You are watching alpha.example for all alerts!")
+
+    replies = say(chat, testUser, poolsChannel, "/watch alpha")
+    expectText(t, replies, "You are already watching alpha.example for " +
+                           "all alerts!")
+
+    replies = say(chat, testUser, poolsChannel, "/watch beta only api,stale")
+    expectText(t, replies, "You are watching beta.example for only api, " +
+                           "stale!")
+
+    if !wantsEvent(&globalInfo.pools[1], testUser, eventApi) ||
+       wantsEvent(&globalInfo.pools[1], testUser, eventFork) {
+        t.Errorf("Watching the wrong alerts: %v",
+                 globalInfo.pools[1].watchEvents)
+    }
+
+    replies = say(chat, testUser, poolsChannel, "/watch beta only dance")
+    expectText(t, replies, "Unknown alert dance - pick from api, fork, " +
+                           "stale.")
+
+    resetRateLimits()
+
+    replies = say(chat, testUser, poolsChannel, "/watch beta only")
+    expectText(t, replies, "Say which alerts you want after `only`")
+
+    replies = say(chat, testUser, poolsChannel, "/mywatches")
+    expectText(t, replies, "alpha.example")
+    expectText(t, replies, "only api, stale")
+    expectNoText(t, replies, "down.example")
+
+    /* Kept across restarts */
+    claims, err := getClaims()
+
+    if err != nil || len(claims) != 2 {
+        t.Errorf("Watches not saved: %v %v", claims, err)
+    }
+
+    replies = say(chat, testUser, poolsChannel, "/unwatch alpha")
+    expectText(t, replies, "You are no longer watching alpha.example!")
+
+    replies = say(chat, testUser, poolsChannel, "/unwatch alpha")
+    expectText(t, replies, "You are not watching alpha.example!")
+
+    replies = say(chat, testOtherUser, poolsChannel, "/watch all")
+    expectText(t, replies, "You are watching alpha.example, " +
+                           "beta.example, down.example for all alerts!")
+
+    for _, v := range globalInfo.pools {
+        if !elem(testOtherUser, v.claimees) {
+            t.Errorf("Not watching %s", v.url)
+        }
+    }
+
+    replies = say(chat, testOtherUser, poolsChannel, "/unwatch all")
+    expectText(t, replies, "You are no longer watching " +
+                           "alpha.example, beta.example, " +
+                           "down.example!")
+}
+
+func TestUnclaimCommand(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    replies := say(chat, testUser, botsChannel, "/unclaim alpha")
+    expectText(t, replies, "You are not a verified operator of " +
+                           "alpha.example!")
+
+    globalInfo.pools[0].operators = []string{testUser}
+
+    replies = say(chat, testUser, botsChannel, "/unclaim alpha")
+    expectText(t, replies, "You are no longer an operator of alpha.example.")
+
+    if len(globalInfo.pools[0].operators) != 0 {
+        t.Errorf("Still an operator")
+    }
+}
+
+func TestThresholdCommand(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    replies := say(chat, testUser, botsChannel, "/threshold alpha")
+    expectText(t, replies, "You need to be a pool operator to use " +
+                           "`/threshold`!")
+
+    globalInfo.pools[1].operators = []string{testUser}
+
+    /* Operating one pool doesn't let them change the others */
+    replies = say(chat, testUser, botsChannel, "/threshold alpha 10")
+    expectText(t, replies, "Only pool operators and verified operators of " +
+                           "alpha.example can change its threshold!")
+
+    replies = say(chat, testUser, botsChannel, "/threshold beta")
+    expectText(t, replies, "beta.example is alerted about when it is more " +
+                           "than")
+
+    replies = say(chat, testUser, botsChannel, "/threshold beta 1000000")
+    expectText(t, replies, "The threshold must be between")
+
+    replies = say(chat, testUser, botsChannel, "/threshold beta 10")
+    expectText(t, replies, "beta.example will be alerted about when it is " +
+                           "more than 10 blocks from the median.")
+
+    if globalInfo.pools[1].maxDifference != 10 {
+        t.Errorf("Threshold is %d", globalInfo.pools[1].maxDifference)
+    }
+
+    replies = say(chat, testUser, botsChannel, "/threshold beta default")
+    expectText(t, replies, "beta.example will be alerted about")
+
+    if globalInfo.pools[1].maxDifference != 0 {
+        t.Errorf("Threshold not reset")
+    }
+}
+
+func TestSilenceCommands(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    replies := say(chat, testAdmin, botsChannel, "/silence alpha")
+    expectText(t, replies, "Alerts for alpha.example are silenced for " +
+                           "1h0m0s.")
+
+    replies = say(chat, testAdmin, botsChannel, "/silence alpha soon")
+    expectText(t, replies, "soon isn't a valid duration")
+
+    replies = say(chat, testAdmin, botsChannel, "/unsilence alpha")
+    expectText(t, replies, "Alerts for alpha.example are no longer " +
+                           "silenced.")
+
+    replies = say(chat, testAdmin, botsChannel, "/unsilence alpha")
+    expectText(t, replies, "Alerts for alpha.example aren't silenced!")
+
+    replies = say(chat, testAdmin, botsChannel, "/silence all 30m")
+    expectText(t, replies, "Alerts for all pools are silenced for 30m0s.")
+
+    if !globalInfo.silencedUntil.Equal(now().Add(time.Minute * 30)) {
+        t.Errorf("Silenced until %s", globalInfo.silencedUntil)
+    }
+
+    replies = say(chat, testAdmin, botsChannel, "/unsilence all")
+    expectText(t, replies, "Alerts for all pools are no longer silenced.")
+
+    /* A verified operator can only silence their own pool */
+    globalInfo.pools[1].operators = []string{testUser}
+
+    replies = say(chat, testUser, botsChannel, "/silence alpha")
+    expectText(t, replies, "Only pool operators and verified operators of " +
+                           "alpha.example can silence it!")
+
+    replies = say(chat, testUser, botsChannel, "/silence beta")
+    expectText(t, replies, "Alerts for beta.example are silenced for " +
+                           "1h0m0s.")
+
+    /* Only admins can turn a pool's alerts off for longer than a day */
+    replies = say(chat, testUser, botsChannel, "/silence beta 48h")
+    expectText(t, replies, "Only admins can silence alerts for longer " +
+                           "than 24 hours!")
+
+    replies = say(chat, testAdmin, botsChannel, "/silence beta 48h")
+    expectText(t, replies, "Alerts for beta.example are silenced for " +
+                           "48h0m0s.")
+}
+
+func TestPoolsJSONCommand(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    writePoolsList(t, "alpha.example", "beta.example")
+
+    replies := say(chat, testUser, botsChannel, "/poolsjson")
+    expectText(t, replies, "You need to be an admin to use `/poolsjson`!")
+
+    replies = say(chat, testAdmin, botsChannel, "/poolsjson")
+    expectText(t, replies, "No problems found in the pools lists")
+}
+
+func TestPoolAdminCommands(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    writePoolsList(t, "alpha.example", "beta.example")
+    stubPoolApis(map[string]int{"alpha.example": 1000,
+                                "beta.example": 1000,
+                                "gamma.example": 1000})
+
+    replies := say(chat, testUser, botsChannel,
+                   "/pooladd gamma.example https://gamma.example/api/ " +
+                   "forknote")
+    expectText(t, replies, "You need to be an admin to use `/pooladd`!")
+
+    replies = say(chat, testAdmin, botsChannel,
+                  "/pooladd gamma.example https://gamma.example/api/ " +
+                  "dance")
+    expectText(t, replies, "Unknown type dance! It should be forknote")
+
+    replies = say(chat, testAdmin, botsChannel,
+                  "/pooladd gamma.example https://gamma.example/api/ " +
+                  "forknote")
+    expectText(t, replies, "Added gamma.example.")
+
+    if findPool("gamma.example") == nil {
+        t.Fatalf("gamma.example not added")
+    }
+
+    replies = say(chat, testAdmin, botsChannel,
+                  "/pooladd gamma.example https://gamma.example/api/ " +
+                  "forknote")
+    expectText(t, replies, "gamma.example is already in the pools list!")
+
+    replies = say(chat, testAdmin, botsChannel,
+                  "/pooledit beta.example api=https://api.beta.example/")
+    expectText(t, replies, "Changed beta.example.")
+
+    if v := findPool("beta.example"); v == nil ||
+       v.api != "https://api.beta.example/" {
+        t.Errorf("beta.example not changed: %+v", v)
+    }
+
+    replies = say(chat, testAdmin, botsChannel,
+                  "/pooledit beta.example height=5")
+    expectText(t, replies, "Can't change height! Only api and type can " +
+                           "be changed.")
+
+    replies = say(chat, testAdmin, botsChannel, "/poolremove alpha.example")
+    expectText(t, replies, "Removed alpha.example.")
+
+    if findPool("alpha.example") != nil {
+        t.Errorf("alpha.example not removed")
+    }
+}
+
+func TestCommandChannels(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    replies := say(chat, testUser, "other", "/height")
+    expectText(t, replies, "You can only use `/height` in the #stats or " +
+                           "#bots channels!")
+
+    /* Admins can use it anywhere */
+    replies = say(chat, testAdmin, "other", "/height")
+    expectText(t, replies, "Median pool height: 1000")
+}
+
+func TestCommandRateLimit(t *testing.T) {
+    chat := setupCommandTest(t)
+
+    replies := say(chat, testUser, botsChannel, "/heights")
+    expectText(t, replies, "alpha.example")
+
+    /* Someone else asks again straight away */
+    replies = say(chat, testOtherUser, botsChannel, "/heights")
+
+    if len(replies) != 1 || !strings.HasPrefix(replies[0].msg.Content,
+                                               "This was posted 0s ago, " +
+                                               "see above") {
+        t.Errorf("Expected a pointer to the last answer, got %+v", replies)
+    }
+
+    limited := false
+
+    for i := 0; i < 20; i++ {
+        replies = say(chat, testUser, botsChannel, "/height")
+
+        if strings.Contains(allText(replies),
+                            "You are using `/height` too often") {
+            limited = true
+            break
+        }
+    }
+
+    if !limited {
+        t.Fatalf("Never rate limited")
+    }
+
+    /* Only told once */
+    replies = say(chat, testUser, botsChannel, "/height")
+
+    if len(replies) != 0 {
+        t.Errorf("Told about the rate limit again: %+v", replies)
+    }
+
+    /* The bucket fills back up */
+    clock.(*FakeClock).advance(time.Minute * 5)
+
+    replies = say(chat, testUser, botsChannel, "/height")
+    expectText(t, replies, "Median pool height: 1000")
+
+    /* And the last answer has scrolled away */
+    replies = say(chat, testOtherUser, botsChannel, "/heights")
+    expectText(t, replies, "alpha.example")
+}
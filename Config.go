@@ -0,0 +1,92 @@
+package main
+
+import (
+    "os"
+    "encoding/json"
+    "io/ioutil"
+)
+
+/* Optional settings, read from config.json if it exists. Everything in here
+   has a sensible default, so the bot runs fine without the file */
+const configFile string = "config.json"
+
+type Config struct {
+    /* Extra places to send alerts to, besides the discord pools channel */
+    Notifiers   []NotifierConfig `json:"notifiers"`
+
+    /* "embed" (the default) to post statuses and alerts as rich embeds, or
+       "code" for the fixed width tables */
+    DiscordFormat string `json:"discordFormat"`
+
+    /* Keep one pinned status message in the pools channel up to date,
+       instead of posting the full table every time something changes */
+    StatusBoard bool `json:"statusBoard"`
+
+    /* Also accept commands typed as normal messages, as well as slash
+       commands. Defaults to true */
+    TextCommands *bool `json:"textCommands"`
+
+    /* What text commands start with. Defaults to / */
+    CommandPrefix string `json:"commandPrefix"`
+
+    /* Which discord roles can use which commands */
+    Permissions PermissionsConfig `json:"permissions"`
+
+    /* How often commands can be used */
+    RateLimits  RateLimitConfig `json:"rateLimits"`
+
+    /* The mail server for email alerts. Leave the host empty to disable
+       them */
+    Smtp        SmtpConfig `json:"smtp"`
+
+    /* How much to log, and where */
+    Log         LogConfig `json:"log"`
+
+    /* Serves the prometheus metrics and the JSON API */
+    Http        HttpConfig `json:"http"`
+
+    /* When /healthz and /readyz start failing */
+    Health      HealthConfig `json:"health"`
+
+    /* Pools lists to merge, in order, as urls or local files. Defaults to
+       the turtlecoin-pools-json one */
+    PoolSources []string `json:"poolSources"`
+
+    /* Local additions and fixes to the pools list. Defaults to
+       pool-overrides.json */
+    PoolOverrides string `json:"poolOverrides"`
+
+    /* Record the pool API responses, to replay later */
+    Archive     ArchiveConfig `json:"archive"`
+}
+
+var config Config
+
+func textCommandsEnabled() bool {
+    return config.TextCommands == nil || *config.TextCommands
+}
+
+func getConfig() (Config, error) {
+    var c Config
+
+    /* No config file, use the defaults */
+    if _, err := os.Stat(configFile); err != nil {
+        return c, nil
+    }
+
+    body, err := ioutil.ReadFile(configFile)
+
+    if err != nil {
+        logError("Failed to read config", Fields{"file": configFile,
+                                                 "error": err})
+        return c, err
+    }
+
+    if err := json.Unmarshal(body, &c); err != nil {
+        logError("Failed to parse config", Fields{"file": configFile,
+                                                  "error": err})
+        return c, err
+    }
+
+    return c, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "strings"
+    "io/ioutil"
+    "path/filepath"
+    "github.com/bwmarrin/discordgo"
+)
+
+/* Who commands typed into the console come from */
+const consoleUserID string = "console"
+
+/* Copies a state file, if the bot has made it yet */
+func copyStateFile(from string, to string) error {
+    body, err := ioutil.ReadFile(from)
+
+    if os.IsNotExist(err) {
+        return nil
+    }
+
+    if err != nil {
+        return err
+    }
+
+    return ioutil.WriteFile(to, body, 0644)
+}
+
+/* Points the state files at copies in a scratch directory, so trying out
+   /watch or /threshold in the console, or running check from cron, doesn't
+   change what the real bot reads. Returns the directory, to be removed
+   once we're done */
+func useScratchState() (string, error) {
+    dir, err := ioutil.TempDir("", "poolbot-console")
+
+    if err != nil {
+        return "", err
+    }
+
+    files := []*string{&claimsFile, &emailsFile, &operatorsFile,
+                       &poolsCacheFile}
+
+    for _, file := range files {
+        scratch := filepath.Join(dir, filepath.Base(*file))
+
+        if err := copyStateFile(*file, scratch); err != nil {
+            os.RemoveAll(dir)
+            return "", err
+        }
+
+        *file = scratch
+    }
+
+    overrides := filepath.Join(dir, defaultPoolOverridesFile)
+
+    if err := copyStateFile(poolOverridesFile(), overrides); err != nil {
+        os.RemoveAll(dir)
+        return "", err
+    }
+
+    config.PoolOverrides = overrides
+
+    return dir, nil
+}
+
+/* Sets up for running on the real pools without discord. Alerts and
+   replies are printed rather than posted. If useCache is set, the cached
+   pools lists are used when they can't be downloaded. Returns the scratch
+   directory holding the state files, to be removed once we're done */
+func setupConsole(useCache bool) (*FakeChat, string, error) {
+    if err := setupOffline(); err != nil {
+        return nil, "", err
+    }
+
+    /* Tables read better than embeds in a terminal, and we don't want to
+       mix our incidents in with the real bot's */
+    config.DiscordFormat = discordFormatCode
+    config.StatusBoard = false
+    incidents.file = ""
+
+    scratch, err := useScratchState()
+
+    if err != nil {
+        logError("Failed to copy the state files", Fields{"error": err})
+        return nil, "", err
+    }
+
+    if err := archive.open(config.Archive); err != nil {
+        logError("Failed to open archive",
+                 Fields{"file": config.Archive.File, "error": err})
+        os.RemoveAll(scratch)
+        return nil, "", err
+    }
+
+    if _, err := updatePools(useCache); err != nil {
+        os.RemoveAll(scratch)
+        return nil, "", err
+    }
+
+    chat := newFakeChat(consoleUserID)
+    chat.onSend = printFakeMessage
+
+    return chat, scratch, nil
+}
+
+/* --console - the usual polling and alerts, with commands read from
+   stdin */
+func consoleMain() {
+    chat, scratch, err := setupConsole(true)
+
+    if err != nil {
+        os.Exit(1)
+    }
+
+    defer os.RemoveAll(scratch)
+
+    go heightWatcher(chat)
+    go poolUpdater(chat)
+
+    fmt.Printf("Console mode. Type %shelp for the commands, or Ctrl-D to " +
+               "quit.\n\n", commandPrefix())
+
+    scanner := bufio.NewScanner(os.Stdin)
+
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+
+        if line == "" {
+            continue
+        }
+
+        /* No need to type the prefix */
+        if !strings.HasPrefix(line, commandPrefix()) {
+            line = commandPrefix() + line
+        }
+
+        c := &CommandContext{session: chat, channelID: botsChannel,
+                             userID: consoleUserID, level: levelAdmin}
+
+        if !runTextCommand(c, line) {
+            fmt.Printf("Unknown command %s\n\n", line)
+        }
+    }
+}
+
+/* check - polls every pool once and prints the table. Exits with 1 if any
+   pool is down or forked, or 2 if we couldn't check them at all, so it can
+   be run from cron */
+func checkMain() {
+    _, scratch, err := setupConsole(false)
+
+    if err != nil {
+        os.Exit(2)
+    }
+
+    /* Done with them before we exit */
+    os.RemoveAll(scratch)
+
+    for _, msg := range heightsMessages() {
+        fmt.Println(fakeMessageText(FakeMessage{msg: msg}))
+    }
+
+    unhealthy := 0
+
+    for index, _ := range globalInfo.pools {
+        if poolStatus(&globalInfo.pools[index]) != "Ok" {
+            unhealthy++
+        }
+    }
+
+    if unhealthy != 0 {
+        fmt.Printf("\n%d of %d pools are unhealthy\n", unhealthy,
+                   len(globalInfo.pools))
+        os.Exit(1)
+    }
+}
+
+/* The text of a message, roughly as discord would show it */
+func fakeMessageText(m FakeMessage) string {
+    var content string
+    var embeds []*discordgo.MessageEmbed
+
+    switch {
+    case m.msg != nil:
+        content, embeds = m.msg.Content, m.msg.Embeds
+    case m.response != nil && m.response.Data != nil:
+        content, embeds = m.response.Data.Content, m.response.Data.Embeds
+    case m.followup != nil:
+        content, embeds = m.followup.Content, m.followup.Embeds
+    }
+
+    lines := make([]string, 0)
+
+    /* The code blocks are only there to make discord use a fixed width
+       font */
+    content = strings.Replace(content, "```", "", -1)
+
+    if content != "" {
+        lines = append(lines, content)
+    }
+
+    for _, embed := range embeds {
+        if embed.Title != "" {
+            lines = append(lines, "== " + embed.Title + " ==")
+        }
+
+        if embed.Description != "" {
+            lines = append(lines, embed.Description)
+        }
+
+        for _, field := range embed.Fields {
+            lines = append(lines, field.Name + ": " + field.Value)
+        }
+    }
+
+    return strings.Join(lines, "\n")
+}
+
+func printFakeMessage(m FakeMessage) {
+    fmt.Printf("--- #%s ---\n%s\n\n", m.channelID, fakeMessageText(m))
+}
+
+func printHeights() {
+    for _, p := range globalInfo.pools {
+        fmt.Printf("%-30s %10d\n", p.url, p.height)
+    }
+
+    fmt.Println()
+}
+
+/* Reads the config for the console or replaying, without connecting to
+   anything */
+func setupOffline() error {
+    c, err := getConfig()
+
+    if err != nil {
+        return err
+    }
+
+    config = c
+
+    if err := setupLogger(config.Log); err != nil {
+        logError("Failed to setup logging", Fields{"error": err})
+        return err
+    }
+
+    return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+    "os"
+    "strings"
+    "testing"
+    "io/ioutil"
+)
+
+/* The real bot's state, which the console mustn't change */
+var realState = map[string]string {
+    "claims.txt": "a.example:" + testUser + "\n",
+    "operators.json": "{}",
+    "pool-overrides.json": "{\"pools\":[]}",
+}
+
+func TestConsoleUsesScratchState(t *testing.T) {
+    setupTest(t)
+
+    /* Put back what the console points elsewhere */
+    oldFiles := []string{claimsFile, emailsFile, operatorsFile,
+                         poolsCacheFile}
+
+    t.Cleanup(func() {
+        claimsFile, emailsFile = oldFiles[0], oldFiles[1]
+        operatorsFile, poolsCacheFile = oldFiles[2], oldFiles[3]
+    })
+
+    for file, body := range realState {
+        if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+            t.Fatalf("Failed to write %s: %s", file, err)
+        }
+    }
+
+    writePoolsList(t, "a.example", "b.example")
+    stubPoolApis(map[string]int{"a.example": 1000, "b.example": 1000,
+                                "c.example": 1000})
+
+    body := "{\"poolSources\":[\"pools.json\"]}"
+
+    if err := ioutil.WriteFile("config.json", []byte(body),
+                               0644); err != nil {
+        t.Fatalf("Failed to write config: %s", err)
+    }
+
+    chat, scratch, err := setupConsole(false)
+
+    if err != nil {
+        t.Fatalf("Failed to setup console: %s", err)
+    }
+
+    defer os.RemoveAll(scratch)
+
+    logger.out = ioutil.Discard
+    chat.onSend = nil
+
+    /* The console still knows who is watching what */
+    if v := findPool("a.example"); v == nil || !elem(testUser, v.claimees) {
+        t.Fatalf("Watches not copied: %+v", v)
+    }
+
+    run := func(channelID string, line string) {
+        c := &CommandContext{session: chat, channelID: channelID,
+                             userID: consoleUserID, level: levelAdmin}
+
+        if !runTextCommand(c, line) {
+            t.Fatalf("Unknown command %s", line)
+        }
+    }
+
+    run(botsChannel, "/pooladd c.example https://c.example/api/ forknote")
+    run(botsChannel, "/poolremove a.example")
+    run(botsChannel, "/threshold b.example 10")
+    run(poolsChannel, "/watch b.example")
+
+    expectText(t, chat.messages(), "Added c.example.")
+    expectText(t, chat.messages(), "Removed a.example.")
+    expectText(t, chat.messages(), "You are watching b.example")
+
+    for file, body := range realState {
+        current, err := ioutil.ReadFile(file)
+
+        if err != nil || string(current) != body {
+            t.Errorf("%s changed to %q", file, current)
+        }
+    }
+
+    for _, file := range []string{"pools-cache.json", "emails.txt"} {
+        if _, err := os.Stat(file); err == nil {
+            t.Errorf("%s written outside the scratch directory", file)
+        }
+    }
+
+    /* They went to the scratch copies instead */
+    claims, err := ioutil.ReadFile(claimsFile)
+
+    if err != nil || !strings.Contains(string(claims),
+                                       "b.example:" + consoleUserID) {
+        t.Errorf("Watch not saved in %s: %q %v", claimsFile, claims, err)
+    }
+
+    overrides, err := getPoolOverrides()
+
+    if err != nil || len(overrides.Pools) != 2 {
+        t.Errorf("Overrides not saved in %s: %+v %v", poolOverridesFile(),
+                 overrides, err)
+    }
+}
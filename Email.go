@@ -0,0 +1,503 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "bufio"
+    "strings"
+    "regexp"
+    "sync"
+    "errors"
+    "time"
+    "net"
+    "net/mail"
+    "net/smtp"
+    "crypto/tls"
+    "crypto/rand"
+    "math/big"
+)
+
+/* Where we store the verified email addresses watching each pool */
+var emailsFile string = "emails.txt"
+
+/* How long someone has to confirm their email address */
+const emailVerifyTimeout time.Duration = time.Hour
+
+/* How long we wait for the mail server, to connect and to send each
+   email */
+var smtpTimeout time.Duration = time.Second * 30
+
+/* How many emails can be waiting to be sent before we start dropping
+   them */
+const emailQueueSize = 100
+
+/* The mail server we send alerts through */
+type SmtpConfig struct {
+    Host        string `json:"host"`
+    Port        int    `json:"port"`
+    Username    string `json:"username"`
+    Password    string `json:"password"`
+    /* The address the emails are sent from */
+    From        string `json:"from"`
+    /* "starttls" (the default), "tls" for implicit TLS, usually port 465, or
+       "none" for a local mail server */
+    Security    string `json:"security"`
+}
+
+/* An email address that has been given a code, but not confirmed it yet */
+type PendingEmail struct {
+    pool        string
+    address     string
+    userID      string
+    expires     time.Time
+}
+
+/* Codes we have sent out, and who they belong to. Commands can run at
+   the same time, so they need a lock */
+type PendingEmails struct {
+    sync.Mutex
+    codes       map[string]PendingEmail
+}
+
+var pendingEmails = PendingEmails{codes: make(map[string]PendingEmail)}
+
+func (p *PendingEmails) add(code string, pending PendingEmail) {
+    p.Lock()
+    defer p.Unlock()
+
+    p.codes[code] = pending
+}
+
+/* Returns the email waiting on this code, if the user asked for it and it
+   hasn't expired. A code only works once */
+func (p *PendingEmails) take(code string, userID string) (PendingEmail,
+                                                          bool) {
+    p.Lock()
+    defer p.Unlock()
+
+    /* Forget about any codes that have expired */
+    for k, v := range p.codes {
+        if now().After(v.expires) {
+            delete(p.codes, k)
+        }
+    }
+
+    pending, ok := p.codes[code]
+
+    /* Only the person who asked for the code can use it */
+    if !ok || pending.userID != userID {
+        return pending, false
+    }
+
+    delete(p.codes, code)
+
+    return pending, true
+}
+
+/* Who gets emails about a pool, as stored in emails.txt */
+type EmailWatchers struct {
+    addresses   []string
+    /* The user who added each address, so only they can remove it */
+    owners      map[string]string
+}
+
+/* Emails are sent one at a time in the background, so a slow mail server
+   can't hold up the cycle or the commands */
+var emailQueue = make(chan func(), emailQueueSize)
+
+/* Counts the emails queued but not sent yet, so we can wait for them */
+var emailsQueued sync.WaitGroup
+
+var startEmailSender sync.Once
+
+func queueEmail(send func()) {
+    startEmailSender.Do(func() {
+        go emailSender()
+    })
+
+    emailsQueued.Add(1)
+
+    select {
+    case emailQueue <- send:
+    default:
+        emailsQueued.Done()
+        logError("Email queue is full, dropping email", Fields{})
+    }
+}
+
+func emailSender() {
+    for send := range emailQueue {
+        recoverPanics("email sender", send)
+        emailsQueued.Done()
+    }
+}
+
+/* Sends one email per cycle to each address watching a pool that changed */
+type EmailNotifier struct {
+    config      SmtpConfig
+}
+
+func (n *EmailNotifier) Name() string {
+    return "email"
+}
+
+/* The emails are queued, so any failures are only logged */
+func (n *EmailNotifier) Notify(alert Alert) error {
+    if len(alert.emailees) == 0 {
+        return nil
+    }
+
+    queueEmail(func() {
+        if err := n.send(alert); err != nil {
+            logWarn("Failed to send alert", Fields{"notifier": n.Name(),
+                                                   "error": err})
+        }
+    })
+
+    return nil
+}
+
+func (n *EmailNotifier) send(alert Alert) error {
+    var failed []string
+
+    for _, address := range alert.emailees {
+        err := sendEmail(n.config, address,
+                         "TurtleCoin pool status changed", alert.text)
+
+        if err != nil {
+            failed = append(failed, fmt.Sprintf("%s (%s)", address, err))
+        }
+    }
+
+    if len(failed) != 0 {
+        return errors.New("Failed to email " + strings.Join(failed, ", "))
+    }
+
+    return nil
+}
+
+/* Email alerts are only enabled if there is a mail server in the config */
+func smtpEnabled() bool {
+    return config.Smtp.Host != ""
+}
+
+func sendEmail(c SmtpConfig, to string, subject string, body string) error {
+    port := c.Port
+
+    if port == 0 {
+        if c.Security == "tls" {
+            port = 465
+        } else {
+            port = 587
+        }
+    }
+
+    address := net.JoinHostPort(c.Host, fmt.Sprintf("%d", port))
+    tlsConfig := &tls.Config{ServerName: c.Host}
+
+    dialer := &net.Dialer{Timeout: smtpTimeout}
+
+    var conn net.Conn
+    var err error
+
+    if c.Security == "tls" {
+        conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+    } else {
+        conn, err = dialer.Dial("tcp", address)
+    }
+
+    if err != nil {
+        return err
+    }
+
+    /* Covers the whole conversation, so a server that stops answering
+       can't keep us waiting */
+    conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+    client, err := smtp.NewClient(conn, c.Host)
+
+    if err != nil {
+        conn.Close()
+        return err
+    }
+
+    defer client.Close()
+
+    if c.Security == "" || c.Security == "starttls" {
+        if err := client.StartTLS(tlsConfig); err != nil {
+            return err
+        }
+    }
+
+    if c.Username != "" {
+        auth := smtp.PlainAuth("", c.Username, c.Password, c.Host)
+
+        if err := client.Auth(auth); err != nil {
+            return err
+        }
+    }
+
+    if err := client.Mail(c.From); err != nil {
+        return err
+    }
+
+    if err := client.Rcpt(to); err != nil {
+        return err
+    }
+
+    w, err := client.Data()
+
+    if err != nil {
+        return err
+    }
+
+    msg := fmt.Sprintf("From: %s\r\n" +
+                       "To: %s\r\n" +
+                       "Subject: %s\r\n" +
+                       "Date: %s\r\n" +
+                       "MIME-Version: 1.0\r\n" +
+                       "Content-Type: text/plain; charset=utf-8\r\n\r\n" +
+                       "%s\r\n",
+                       c.From, to, subject,
+                       time.Now().Format(time.RFC1123Z),
+                       strings.Replace(body, "\n", "\r\n", -1))
+
+    if _, err := w.Write([]byte(msg)); err != nil {
+        return err
+    }
+
+    if err := w.Close(); err != nil {
+        return err
+    }
+
+    return client.Quit()
+}
+
+func writeEmails() {
+    file, err := os.Create(emailsFile)
+
+    if err != nil {
+        logError("Failed to open emails file", Fields{"file": emailsFile,
+                                                      "error": err})
+        return
+    }
+
+    defer file.Close()
+
+    for _, v := range globalInfo.pools {
+        writeEmailWatchers(file, v.url,
+                           EmailWatchers{addresses: v.emailees,
+                                         owners: v.emailOwners})
+    }
+
+    /* Kept in case the pool comes back */
+    for _, url := range dormantEmailURLs() {
+        writeEmailWatchers(file, url, globalInfo.dormantEmails[url])
+    }
+
+    file.Sync()
+}
+
+/* pool:address, or pool:address:user if we know who added it */
+func writeEmailWatchers(file *os.File, url string, w EmailWatchers) {
+    for _, address := range w.addresses {
+        if owner, ok := w.owners[address]; ok {
+            file.WriteString(fmt.Sprintf("%s:%s:%s\n", url, address, owner))
+        } else {
+            file.WriteString(fmt.Sprintf("%s:%s\n", url, address))
+        }
+    }
+}
+
+func getEmails() (map[string]EmailWatchers, error) {
+    emails := make(map[string]EmailWatchers)
+
+    /* File exists */
+    if _, err := os.Stat(emailsFile); err == nil {
+        file, err := os.Open(emailsFile)
+
+        if err != nil {
+            return emails, err
+        }
+
+        defer file.Close()
+
+        scanner := bufio.NewScanner(file)
+
+        /* Pool urls can contain a port, so the address is found by its @.
+           Addresses added before we stored who added them have no user */
+        re := regexp.MustCompile("^(.+):([^:]+@[^:]+?)(?::(\\d+))?$")
+
+        for scanner.Scan() {
+            matches := re.FindStringSubmatch(scanner.Text())
+
+            if len(matches) < 3 {
+                logWarn("Failed to parse email",
+                        Fields{"line": scanner.Text()})
+                continue
+            }
+
+            /* Pool doesn't exist yet, create it */
+            if _, ok := emails[matches[1]]; !ok {
+                emails[matches[1]] = EmailWatchers {
+                    addresses: make([]string, 0),
+                    owners: make(map[string]string),
+                }
+            }
+
+            w := emails[matches[1]]
+
+            w.addresses = append(w.addresses, matches[2])
+
+            if matches[3] != "" {
+                w.owners[matches[2]] = matches[3]
+            }
+
+            emails[matches[1]] = w
+        }
+
+        if err := scanner.Err(); err != nil {
+            logError("Failed to read emails file",
+                     Fields{"file": emailsFile, "error": err})
+            return emails, err
+        }
+    }
+
+    return emails, nil
+}
+
+func makeEmailCode() (string, error) {
+    n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+
+    if err != nil {
+        return "", err
+    }
+
+    return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+/* /watch <pool> email <address> - send a code to the address, which they
+   then need to give us with /verify */
+func watchEmail(c *CommandContext, v *PoolInfo, address string) {
+    if !smtpEnabled() {
+        c.replyPrivate("Email alerts are not enabled on this bot!")
+        return
+    }
+
+    parsed, err := mail.ParseAddress(address)
+
+    if err != nil {
+        c.replyPrivate(fmt.Sprintf("%s doesn't look like a valid email " +
+                                   "address!", address))
+        return
+    }
+
+    address = parsed.Address
+
+    if elem(address, v.emailees) {
+        c.replyPrivate(fmt.Sprintf("%s is already watching %s!", address,
+                                   v.url))
+        return
+    }
+
+    code, err := makeEmailCode()
+
+    if err != nil {
+        logError("Failed to generate email code", Fields{"error": err})
+        return
+    }
+
+    url := v.url
+    smtpConfig := config.Smtp
+
+    body := fmt.Sprintf("Someone asked for alerts about %s to be sent to " +
+                        "this address.\n\nTo confirm, type %sverify %s in " +
+                        "the discord channel. The code expires in %d " +
+                        "minutes.\n\nIf this wasn't you, you can ignore " +
+                        "this email.", url, commandPrefix(), code,
+                        int(emailVerifyTimeout.Minutes()))
+
+    /* We answer once it has been sent, which can take a while */
+    c.deferReply(true)
+
+    queueEmail(func() {
+        err := sendEmail(smtpConfig, address,
+                         "Confirm your TurtleCoin pool alerts", body)
+
+        if err != nil {
+            logWarn("Failed to send verification email",
+                    Fields{"pool": url, "address": address, "error": err})
+
+            c.replyPrivate(fmt.Sprintf("Failed to send an email to %s!",
+                                       address))
+            return
+        }
+
+        pendingEmails.add(code, PendingEmail{pool: url, address: address,
+                                             userID: c.userID,
+                                             expires: now().Add(
+                                                 emailVerifyTimeout)})
+
+        c.replyPrivate(fmt.Sprintf("We've sent a code to %s - type " +
+                                   "`%sverify <code>` to start getting " +
+                                   "alerts for %s.", address,
+                                   commandPrefix(), url))
+    })
+}
+
+/* /verify <code> */
+func verifyCommand(c *CommandContext, args []string) {
+    pending, ok := pendingEmails.take(args[0], c.userID)
+
+    if !ok {
+        c.replyPrivate("That code is invalid or has expired!")
+        return
+    }
+
+    v := findPool(pending.pool)
+
+    if v == nil {
+        c.replyPrivate(fmt.Sprintf("Couldn't find pool %s - it may have " +
+                                   "been removed.", pending.pool))
+        return
+    }
+
+    if !elem(pending.address, v.emailees) {
+        v.emailees = append(v.emailees, pending.address)
+
+        if v.emailOwners == nil {
+            v.emailOwners = make(map[string]string)
+        }
+
+        v.emailOwners[pending.address] = c.userID
+
+        writeEmails()
+    }
+
+    c.replyPrivate(fmt.Sprintf("%s is now watching %s!", pending.address,
+                               v.url))
+}
+
+/* /unwatch <pool> email <address> */
+func unwatchEmail(c *CommandContext, v *PoolInfo, address string) {
+    if !elem(address, v.emailees) {
+        c.replyPrivate(fmt.Sprintf("%s is not watching %s!", address, v.url))
+        return
+    }
+
+    /* Addresses from before we stored who added them can only be removed
+       by an admin */
+    if v.emailOwners[address] != c.userID && c.level < levelAdmin {
+        c.replyPrivate(fmt.Sprintf("Only the person who added %s can stop " +
+                                   "it watching %s!", address, v.url))
+        return
+    }
+
+    v.emailees = deleteElem(address, v.emailees)
+    delete(v.emailOwners, address)
+
+    writeEmails()
+
+    c.replyPrivate(fmt.Sprintf("%s is no longer watching %s!", address,
+                               v.url))
+}
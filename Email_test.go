@@ -0,0 +1,394 @@
+package main
+
+import (
+    "net"
+    "regexp"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+    "io/ioutil"
+    "net/textproto"
+)
+
+/* An email the fake mail server accepted */
+type FakeMail struct {
+    from        string
+    to          []string
+    data        string
+}
+
+/* Just enough of an SMTP server for sendEmail, with no TLS, so it needs
+   "security": "none" */
+type FakeSmtp struct {
+    sync.Mutex
+    listener    net.Listener
+    mails       []FakeMail
+}
+
+func startFakeSmtp(t *testing.T) *FakeSmtp {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+    if err != nil {
+        t.Fatalf("Failed to listen: %s", err)
+    }
+
+    f := &FakeSmtp{listener: listener}
+
+    go func() {
+        for {
+            conn, err := listener.Accept()
+
+            if err != nil {
+                return
+            }
+
+            go f.serve(conn)
+        }
+    }()
+
+    t.Cleanup(func() {
+        listener.Close()
+    })
+
+    return f
+}
+
+func (f *FakeSmtp) port() int {
+    return f.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (f *FakeSmtp) received() []FakeMail {
+    f.Lock()
+    defer f.Unlock()
+
+    return append([]FakeMail{}, f.mails...)
+}
+
+func (f *FakeSmtp) serve(conn net.Conn) {
+    defer conn.Close()
+
+    text := textproto.NewConn(conn)
+
+    var mail FakeMail
+
+    text.PrintfLine("220 fake ESMTP")
+
+    for {
+        line, err := text.ReadLine()
+
+        if err != nil {
+            return
+        }
+
+        verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+        switch verb {
+        case "EHLO", "HELO":
+            text.PrintfLine("250 fake")
+        case "MAIL":
+            mail = FakeMail{from: line}
+            text.PrintfLine("250 OK")
+        case "RCPT":
+            mail.to = append(mail.to, line)
+            text.PrintfLine("250 OK")
+        case "DATA":
+            text.PrintfLine("354 Go ahead")
+
+            data, err := ioutil.ReadAll(text.DotReader())
+
+            if err != nil {
+                return
+            }
+
+            mail.data = string(data)
+
+            f.Lock()
+            f.mails = append(f.mails, mail)
+            f.Unlock()
+
+            text.PrintfLine("250 OK")
+        case "QUIT":
+            text.PrintfLine("221 Bye")
+            return
+        default:
+            text.PrintfLine("502 Not implemented")
+        }
+    }
+}
+
+/* Who the fake server was asked to send to, one entry per email */
+func mailRecipients(mails []FakeMail) []string {
+    to := make([]string, 0)
+
+    for _, mail := range mails {
+        to = append(to, strings.Join(mail.to, ","))
+    }
+
+    return to
+}
+
+func setupEmailTest(t *testing.T) (*FakeChat, *FakeSmtp) {
+    chat := setupTest(t)
+    smtp := startFakeSmtp(t)
+
+    config.Smtp = SmtpConfig{Host: "127.0.0.1", Port: smtp.port(),
+                             From: "bot@example.com", Security: "none"}
+
+    notifiers, err := makeNotifiers(nil)
+
+    if err != nil {
+        t.Fatalf("makeNotifiers failed: %s", err)
+    }
+
+    extraNotifiers = notifiers
+
+    return chat, smtp
+}
+
+func TestEmailBatching(t *testing.T) {
+    chat, smtp := setupEmailTest(t)
+
+    globalInfo.modeHeight = 1000
+
+    down := testPool("down.example", 0)
+    down.apiFailCounter = 3
+    down.emailees = []string{"a@example.com", "b@example.com"}
+
+    forked := testPool("forked.example", 900)
+    forked.emailees = []string{"a@example.com"}
+
+    fine := testPool("fine.example", 1000)
+    fine.emailees = []string{"c@example.com"}
+
+    globalInfo.pools = []PoolInfo{down, forked, fine}
+
+    checkForPoolsWithIssues(chat)
+    emailsQueued.Wait()
+
+    mails := smtp.received()
+
+    /* a@ watches two pools that changed, but only gets one email */
+    if len(mails) != 2 {
+        t.Fatalf("Expected 2 emails, got %d: %v", len(mails),
+                 mailRecipients(mails))
+    }
+
+    to := strings.Join(mailRecipients(mails), " ")
+
+    if !strings.Contains(to, "a@example.com") ||
+       !strings.Contains(to, "b@example.com") ||
+       strings.Contains(to, "c@example.com") {
+        t.Errorf("Emailed the wrong people: %s", to)
+    }
+
+    for _, mail := range mails {
+        if !strings.Contains(mail.data,
+                             "Subject: TurtleCoin pool status changed") ||
+           !strings.Contains(mail.data, "down.example") ||
+           !strings.Contains(mail.data, "forked.example") {
+            t.Errorf("Unexpected email:\n%s", mail.data)
+        }
+    }
+
+    /* Nothing changed, so nothing more is sent */
+    checkForPoolsWithIssues(chat)
+    emailsQueued.Wait()
+
+    if len(smtp.received()) != 2 {
+        t.Errorf("Emailed again without a change")
+    }
+}
+
+var codeRegex = regexp.MustCompile("verify (\\d{6})")
+
+func TestEmailVerify(t *testing.T) {
+    chat, smtp := setupEmailTest(t)
+
+    globalInfo.pools = []PoolInfo{testPool("turtlepool.example", 1000)}
+
+    replies := say(chat, testUser, poolsChannel,
+                   "/watch turtlepool email someone@example.com")
+
+    expectText(t, replies, "We've sent a code to someone@example.com")
+
+    mails := smtp.received()
+
+    if len(mails) != 1 {
+        t.Fatalf("Expected a verification email, got %d", len(mails))
+    }
+
+    if !strings.Contains(mails[0].to[0], "someone@example.com") {
+        t.Errorf("Sent the code to %s", mails[0].to[0])
+    }
+
+    matches := codeRegex.FindStringSubmatch(mails[0].data)
+
+    if len(matches) < 2 {
+        t.Fatalf("No code in email:\n%s", mails[0].data)
+    }
+
+    code := matches[1]
+
+    /* Only the person who asked can use the code */
+    replies = say(chat, testOtherUser, poolsChannel, "/verify " + code)
+    expectText(t, replies, "That code is invalid or has expired!")
+
+    replies = say(chat, testUser, poolsChannel, "/verify 000000x")
+    expectText(t, replies, "That code is invalid or has expired!")
+
+    if len(globalInfo.pools[0].emailees) != 0 {
+        t.Fatalf("Watching before the code was given")
+    }
+
+    replies = say(chat, testUser, poolsChannel, "/verify " + code)
+    expectText(t, replies,
+               "someone@example.com is now watching turtlepool.example!")
+
+    if !elem("someone@example.com", globalInfo.pools[0].emailees) {
+        t.Errorf("Address not added to the pool")
+    }
+
+    emails, err := getEmails()
+
+    saved := emails["turtlepool.example"]
+
+    if err != nil || !elem("someone@example.com", saved.addresses) ||
+       saved.owners["someone@example.com"] != testUser {
+        t.Errorf("Address not saved: %v %v", emails, err)
+    }
+
+    /* Codes only work once */
+    replies = say(chat, testUser, poolsChannel, "/verify " + code)
+    expectText(t, replies, "That code is invalid or has expired!")
+
+    /* Only the person who added it can take it off */
+    replies = say(chat, testOtherUser, poolsChannel,
+                  "/unwatch turtlepool email someone@example.com")
+    expectText(t, replies, "Only the person who added someone@example.com")
+
+    replies = say(chat, testUser, poolsChannel,
+                  "/unwatch turtlepool email someone@example.com")
+    expectText(t, replies,
+               "someone@example.com is no longer watching " +
+               "turtlepool.example!")
+
+    if len(globalInfo.pools[0].emailees) != 0 {
+        t.Errorf("Address still watching after unwatch")
+    }
+}
+
+func TestEmailSecurityConfig(t *testing.T) {
+    setupTest(t)
+
+    config.Smtp = SmtpConfig{Host: "mail.example.com", Security: "ssl"}
+
+    if _, err := makeNotifiers(nil); err == nil {
+        t.Errorf("Expected an unknown security value to be rejected")
+    }
+
+    for _, security := range []string{"", "starttls", "tls", "none"} {
+        config.Smtp.Security = security
+
+        if _, err := makeNotifiers(nil); err != nil {
+            t.Errorf("Security %q rejected: %s", security, err)
+        }
+    }
+}
+
+func TestEmailCodeExpires(t *testing.T) {
+    chat, smtp := setupEmailTest(t)
+
+    globalInfo.pools = []PoolInfo{testPool("turtlepool.example", 1000)}
+
+    say(chat, testUser, poolsChannel,
+        "/watch turtlepool email someone@example.com")
+
+    mails := smtp.received()
+
+    if len(mails) != 1 {
+        t.Fatalf("Expected a verification email, got %d", len(mails))
+    }
+
+    matches := codeRegex.FindStringSubmatch(mails[0].data)
+
+    if len(matches) < 2 {
+        t.Fatalf("No code in email:\n%s", mails[0].data)
+    }
+
+    clock.(*FakeClock).advance(emailVerifyTimeout + time.Minute)
+
+    replies := say(chat, testUser, poolsChannel, "/verify " + matches[1])
+    expectText(t, replies, "That code is invalid or has expired!")
+
+    if len(globalInfo.pools[0].emailees) != 0 {
+        t.Errorf("Watching with an expired code")
+    }
+}
+
+/* Addresses added before we stored who added them are left to the
+   admins */
+func TestEmailUnwatchOldAddress(t *testing.T) {
+    chat, _ := setupEmailTest(t)
+
+    if err := ioutil.WriteFile(emailsFile,
+                               []byte("a.example:old@example.com\n"),
+                               0644); err != nil {
+        t.Fatalf("Failed to write emails: %s", err)
+    }
+
+    writePoolsList(t, "a.example")
+    stubPoolApis(map[string]int{"a.example": 1000})
+
+    if _, err := updatePools(false); err != nil {
+        t.Fatalf("Failed to update pools: %s", err)
+    }
+
+    replies := say(chat, testUser, poolsChannel,
+                   "/unwatch a.example email old@example.com")
+    expectText(t, replies, "Only the person who added old@example.com")
+
+    replies = say(chat, testAdmin, poolsChannel,
+                  "/unwatch a.example email old@example.com")
+    expectText(t, replies, "old@example.com is no longer watching")
+}
+
+/* A mail server that stops answering doesn't keep us waiting */
+func TestEmailTimeout(t *testing.T) {
+    setupTest(t)
+
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+    if err != nil {
+        t.Fatalf("Failed to listen: %s", err)
+    }
+
+    defer listener.Close()
+
+    /* Accepts, but never says hello */
+    go func() {
+        conn, err := listener.Accept()
+
+        if err == nil {
+            defer conn.Close()
+            time.Sleep(time.Second * 2)
+        }
+    }()
+
+    old := smtpTimeout
+    smtpTimeout = time.Millisecond * 200
+    defer func() { smtpTimeout = old }()
+
+    c := SmtpConfig{Host: "127.0.0.1", Security: "none",
+                    Port: listener.Addr().(*net.TCPAddr).Port}
+
+    start := time.Now()
+
+    if err := sendEmail(c, "someone@example.com", "Hi", "Hi"); err == nil {
+        t.Errorf("Sent to a server that never answered")
+    }
+
+    if time.Since(start) > time.Second {
+        t.Errorf("Waited %s for the mail server", time.Since(start))
+    }
+}
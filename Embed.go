@@ -0,0 +1,198 @@
+package main
+
+import (
+    "github.com/bwmarrin/discordgo"
+    "fmt"
+    "time"
+)
+
+/* How discord messages are presented - rich embeds, or the old fixed width
+   tables in code blocks */
+const discordFormatEmbed string = "embed"
+const discordFormatCode string = "code"
+
+const colourOk int = 0x2ecc71
+const colourForked int = 0xe67e22
+const colourApiDown int = 0xe74c3c
+
+/* Discord limits */
+const embedFieldLimit = 25
+const embedsPerMessage = 10
+const embedCharLimit = 6000
+
+/* A single line of the status table */
+type PoolRow struct {
+    url                 string
+    height              int
+    status              string
+    lastFound           string
+    timeStuck           string
+    /* Whether this pool caused the status message to be posted */
+    changed             bool
+}
+
+func useEmbeds() bool {
+    return config.DiscordFormat != discordFormatCode
+}
+
+func statusColour(status string) int {
+    switch status {
+    case "Api Down":
+        return colourApiDown
+    case "Forked":
+        return colourForked
+    default:
+        return colourOk
+    }
+}
+
+func statusEmoji(status string) string {
+    switch status {
+    case "Api Down":
+        return "🔴"
+    case "Forked":
+        return "🟠"
+    default:
+        return "🟢"
+    }
+}
+
+func makeEmbed(title string, description string,
+               colour int) *discordgo.MessageEmbed {
+    return &discordgo.MessageEmbed {
+        Title: title,
+        Description: description,
+        Color: colour,
+        Footer: &discordgo.MessageEmbedFooter {
+            Text: fmt.Sprintf("Median pool height: %d",
+                              globalInfo.modeHeight),
+        },
+        Timestamp: now().Format(time.RFC3339),
+    }
+}
+
+/* Groups the rows by status, one embed (or more, if there are lots of
+   pools) per status, each coloured by that status */
+func makePoolEmbeds(title string, description string,
+                    rows []PoolRow) []*discordgo.MessageEmbed {
+    embeds := make([]*discordgo.MessageEmbed, 0)
+
+    statuses := make([]string, 0)
+
+    for _, row := range rows {
+        if !elem(row.status, statuses) {
+            statuses = append(statuses, row.status)
+        }
+    }
+
+    for _, status := range statuses {
+        var embed *discordgo.MessageEmbed
+
+        for _, row := range rows {
+            if row.status != status {
+                continue
+            }
+
+            if embed == nil || len(embed.Fields) >= embedFieldLimit {
+                embed = makeEmbed(fmt.Sprintf("%s %s", statusEmoji(status),
+                                              status), "",
+                                  statusColour(status))
+                embeds = append(embeds, embed)
+            }
+
+            name := row.url
+
+            if row.changed {
+                name += " (new)"
+            }
+
+            value := fmt.Sprintf("Height: %d\nLast found: %s", row.height,
+                                 row.lastFound)
+
+            if row.timeStuck != "" {
+                value += fmt.Sprintf("\nStuck for: %s", row.timeStuck)
+            }
+
+            embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField {
+                Name: name,
+                Value: value,
+                Inline: true,
+            })
+        }
+    }
+
+    /* Nothing to show, still say something */
+    if len(embeds) == 0 {
+        embeds = append(embeds, makeEmbed(title, description, colourOk))
+    } else {
+        embeds[0].Title = title + " - " + embeds[0].Title
+        embeds[0].Description = description
+    }
+
+    return embeds
+}
+
+func embedLength(e *discordgo.MessageEmbed) int {
+    length := len(e.Title) + len(e.Description)
+
+    if e.Footer != nil {
+        length += len(e.Footer.Text)
+    }
+
+    for _, f := range e.Fields {
+        length += len(f.Name) + len(f.Value)
+    }
+
+    return length
+}
+
+/* Splits the embeds into as few messages as the discord limits allow. The
+   content, if any, goes with the first message, so pings are only sent
+   once */
+func packEmbeds(content string,
+                embeds []*discordgo.MessageEmbed) []*discordgo.MessageSend {
+    messages := make([]*discordgo.MessageSend, 0)
+
+    batch := make([]*discordgo.MessageEmbed, 0)
+    length := 0
+
+    for _, e := range embeds {
+        l := embedLength(e)
+
+        if len(batch) != 0 && (len(batch) >= embedsPerMessage ||
+                               length + l > embedCharLimit) {
+            messages = append(messages, &discordgo.MessageSend {
+                Content: content,
+                Embeds: batch,
+            })
+
+            content = ""
+            batch = make([]*discordgo.MessageEmbed, 0)
+            length = 0
+        }
+
+        batch = append(batch, e)
+        length += l
+    }
+
+    if len(batch) != 0 || content != "" {
+        messages = append(messages, &discordgo.MessageSend {
+            Content: content,
+            Embeds: batch,
+        })
+    }
+
+    return messages
+}
+
+func sendEmbeds(s ChatClient, channel string, content string,
+                embeds []*discordgo.MessageEmbed) error {
+    for _, msg := range packEmbeds(content, embeds) {
+        if _, err := s.ChannelMessageSendComplex(channel, msg); err != nil {
+            metrics.discordSendFailed()
+            return err
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+    "fmt"
+    "sync"
+    "net/http"
+    "encoding/json"
+)
+
+/* The people watching the dashboard, who get told when a cycle finishes */
+type EventStream struct {
+    sync.Mutex
+    clients     map[chan []byte]bool
+}
+
+var eventStream = EventStream {
+    clients: make(map[chan []byte]bool),
+}
+
+/* Sent to the dashboard after each cycle */
+type UpdateEvent struct {
+    Network     NetworkJSON `json:"network"`
+    Pools       []PoolJSON  `json:"pools"`
+}
+
+/* Tells every client about the latest snapshot */
+func (e *EventStream) publish() {
+    apiSnapshot.RLock()
+
+    body, err := json.Marshal(UpdateEvent{Network: apiSnapshot.network,
+                                          Pools: apiSnapshot.pools})
+
+    apiSnapshot.RUnlock()
+
+    if err != nil {
+        logError("Failed to encode update event", Fields{"error": err})
+        return
+    }
+
+    e.Lock()
+    defer e.Unlock()
+
+    for client, _ := range e.clients {
+        /* Don't hold up the cycle for a slow client, they'll get the next
+           one */
+        select {
+        case client <- body:
+        default:
+        }
+    }
+}
+
+/* /api/events - server sent events, one "update" after each cycle */
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+    allowCORS(w)
+
+    flusher, ok := w.(http.Flusher)
+
+    if !ok {
+        http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+
+    client := make(chan []byte, 1)
+
+    eventStream.Lock()
+    eventStream.clients[client] = true
+    eventStream.Unlock()
+
+    defer func() {
+        eventStream.Lock()
+        delete(eventStream.clients, client)
+        eventStream.Unlock()
+    }()
+
+    /* Let the browser know it's connected */
+    fmt.Fprint(w, ": connected\n\n")
+    flusher.Flush()
+
+    for {
+        select {
+        case body := <-client:
+            fmt.Fprintf(w, "event: update\ndata: %s\n\n", body)
+            flusher.Flush()
+        case <-r.Context().Done():
+            return
+        }
+    }
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+    "errors"
+    "strconv"
+    "sync"
+    "github.com/bwmarrin/discordgo"
+)
+
+/* A message the fake has been asked to post */
+type FakeMessage struct {
+    channelID   string
+    /* Empty for replies to an interaction */
+    messageID   string
+    msg         *discordgo.MessageSend
+    /* Only set for replies to an interaction */
+    interaction *discordgo.Interaction
+    response    *discordgo.InteractionResponse
+    followup    *discordgo.WebhookParams
+}
+
+/* A ChatClient that keeps everything in memory, for running without
+   discord. The channels, members and roles it knows about are filled in by
+   whoever uses it */
+type FakeChat struct {
+    sync.Mutex
+    userID      string
+    channels    map[string]*discordgo.Channel
+    /* Keyed by guild and user ID */
+    members     map[string]*discordgo.Member
+    /* Keyed by guild and role ID */
+    roles       map[string]*discordgo.Role
+    sent        []FakeMessage
+    pinned      []string
+    deleted     []string
+    lastID      int
+    /* Called with each message as it is sent, if set */
+    onSend      func(m FakeMessage)
+    /* If set, edits and interaction responses fail with these instead */
+    editErr     error
+    respondErr  error
+}
+
+func newFakeChat(userID string) *FakeChat {
+    return &FakeChat {
+        userID: userID,
+        channels: make(map[string]*discordgo.Channel),
+        members: make(map[string]*discordgo.Member),
+        roles: make(map[string]*discordgo.Role),
+    }
+}
+
+func (f *FakeChat) addChannel(channelID string, guildID string) {
+    f.Lock()
+    defer f.Unlock()
+
+    f.channels[channelID] = &discordgo.Channel{ID: channelID,
+                                               GuildID: guildID}
+}
+
+func (f *FakeChat) addMember(guildID string, userID string, roles []string) {
+    f.Lock()
+    defer f.Unlock()
+
+    f.members[guildID + ":" + userID] = &discordgo.Member {
+        GuildID: guildID,
+        User: &discordgo.User{ID: userID},
+        Roles: roles,
+    }
+}
+
+func (f *FakeChat) addRole(guildID string, roleID string, name string) {
+    f.Lock()
+    defer f.Unlock()
+
+    f.roles[guildID + ":" + roleID] = &discordgo.Role{ID: roleID, Name: name}
+}
+
+/* Everything sent so far, oldest first */
+func (f *FakeChat) messages() []FakeMessage {
+    f.Lock()
+    defer f.Unlock()
+
+    return append([]FakeMessage{}, f.sent...)
+}
+
+/* Must be called with the fake locked */
+func (f *FakeChat) record(m FakeMessage) {
+    f.sent = append(f.sent, m)
+
+    if f.onSend != nil {
+        f.onSend(m)
+    }
+}
+
+func (f *FakeChat) nextID() string {
+    f.lastID++
+    return strconv.Itoa(f.lastID)
+}
+
+func (f *FakeChat) BotUserID() string {
+    return f.userID
+}
+
+func (f *FakeChat) ChannelMessageSendComplex(channelID string,
+        msg *discordgo.MessageSend) (*discordgo.Message, error) {
+    f.Lock()
+    defer f.Unlock()
+
+    id := f.nextID()
+
+    f.record(FakeMessage{channelID: channelID, messageID: id, msg: msg})
+
+    return &discordgo.Message{ID: id, ChannelID: channelID,
+                              Content: msg.Content}, nil
+}
+
+func (f *FakeChat) ChannelMessageEditComplex(
+        edit *discordgo.MessageEdit) (*discordgo.Message, error) {
+    f.Lock()
+    defer f.Unlock()
+
+    if f.editErr != nil {
+        return nil, f.editErr
+    }
+
+    msg := &discordgo.MessageSend{}
+
+    if edit.Content != nil {
+        msg.Content = *edit.Content
+    }
+
+    if edit.Embeds != nil {
+        msg.Embeds = *edit.Embeds
+    }
+
+    f.record(FakeMessage{channelID: edit.Channel, messageID: edit.ID,
+                         msg: msg})
+
+    return &discordgo.Message{ID: edit.ID, ChannelID: edit.Channel,
+                              Content: msg.Content}, nil
+}
+
+func (f *FakeChat) ChannelMessageDelete(channelID string,
+        messageID string) error {
+    f.Lock()
+    defer f.Unlock()
+
+    f.deleted = append(f.deleted, messageID)
+
+    return nil
+}
+
+func (f *FakeChat) ChannelMessagePin(channelID string, messageID string) error {
+    f.Lock()
+    defer f.Unlock()
+
+    f.pinned = append(f.pinned, messageID)
+
+    return nil
+}
+
+func (f *FakeChat) Channel(channelID string) (*discordgo.Channel, error) {
+    f.Lock()
+    defer f.Unlock()
+
+    if channel, ok := f.channels[channelID]; ok {
+        return channel, nil
+    }
+
+    return nil, errors.New("Unknown channel " + channelID)
+}
+
+func (f *FakeChat) GuildMember(guildID string,
+        userID string) (*discordgo.Member, error) {
+    f.Lock()
+    defer f.Unlock()
+
+    if member, ok := f.members[guildID + ":" + userID]; ok {
+        return member, nil
+    }
+
+    return nil, errors.New("Unknown member " + userID)
+}
+
+func (f *FakeChat) Role(guildID string,
+        roleID string) (*discordgo.Role, error) {
+    f.Lock()
+    defer f.Unlock()
+
+    if role, ok := f.roles[guildID + ":" + roleID]; ok {
+        return role, nil
+    }
+
+    return nil, errors.New("Unknown role " + roleID)
+}
+
+/* Direct message channels are made up as they are asked for */
+func (f *FakeChat) UserChannelCreate(
+        userID string) (*discordgo.Channel, error) {
+    f.Lock()
+    defer f.Unlock()
+
+    channel := &discordgo.Channel{ID: "dm:" + userID,
+                                  Type: discordgo.ChannelTypeDM}
+
+    f.channels[channel.ID] = channel
+
+    return channel, nil
+}
+
+func (f *FakeChat) InteractionRespond(i *discordgo.Interaction,
+        response *discordgo.InteractionResponse) error {
+    f.Lock()
+    defer f.Unlock()
+
+    if f.respondErr != nil {
+        return f.respondErr
+    }
+
+    f.record(FakeMessage{channelID: i.ChannelID, interaction: i,
+                         response: response})
+
+    return nil
+}
+
+func (f *FakeChat) FollowupMessageCreate(i *discordgo.Interaction,
+        params *discordgo.WebhookParams) (*discordgo.Message, error) {
+    f.Lock()
+    defer f.Unlock()
+
+    id := f.nextID()
+
+    f.record(FakeMessage{channelID: i.ChannelID, messageID: id,
+                         interaction: i, followup: params})
+
+    return &discordgo.Message{ID: id, ChannelID: i.ChannelID,
+                              Content: params.Content}, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "sync"
+    "time"
+    "net/http"
+    "net/http/httptest"
+    "compress/flate"
+    "compress/gzip"
+)
+
+/* What a fake pool does in one cycle */
+type FakePoolStep struct {
+    Height      int    `json:"height"`
+    /* Unix time the pool last found a block. Defaults to now */
+    LastFound   int64  `json:"lastFound"`
+    /* How long to wait before answering, e.g. "2s" */
+    Latency     string `json:"latency"`
+    /* "gzip", "deflate", or "gzip-multi" for the " ", "gzip"
+       Content-Encoding some pools send */
+    Encoding    string `json:"encoding"`
+    /* "status" for a 500, "garbage" for a body that can't be parsed,
+       "hang" to never answer in time, or "close" to drop the connection */
+    Fail        string `json:"fail"`
+}
+
+type FakePoolScript struct {
+    Url         string         `json:"url"`
+    /* "forknote" (the default) or "node.js" */
+    Type        string         `json:"type"`
+    /* One for each cycle. The last one repeats */
+    Steps       []FakePoolStep `json:"steps"`
+}
+
+/* A local pool API that follows a script */
+type FakePool struct {
+    sync.Mutex
+    script      FakePoolScript
+    step        int
+    server      *httptest.Server
+}
+
+/* Longer than downloadApiLink waits */
+func fakeHangTime() time.Duration {
+    return apiTimeout * 2
+}
+
+func startFakePool(script FakePoolScript) *FakePool {
+    if script.Type == "" {
+        script.Type = "forknote"
+    }
+
+    p := &FakePool{script: script}
+
+    p.server = httptest.NewServer(http.HandlerFunc(p.serve))
+
+    return p
+}
+
+func (p *FakePool) current() FakePoolStep {
+    p.Lock()
+    defer p.Unlock()
+
+    if len(p.script.Steps) == 0 {
+        return FakePoolStep{Fail: "status"}
+    }
+
+    if p.step >= len(p.script.Steps) {
+        return p.script.Steps[len(p.script.Steps) - 1]
+    }
+
+    return p.script.Steps[p.step]
+}
+
+func (p *FakePool) advance() {
+    p.Lock()
+    defer p.Unlock()
+
+    p.step++
+}
+
+func (p *FakePool) close() {
+    p.server.Close()
+}
+
+/* The pool, as it would be read from the pools json */
+func (p *FakePool) poolInfo() PoolInfo {
+    return PoolInfo{url: p.script.Url, api: p.server.URL + "/",
+                    poolType: p.script.Type}
+}
+
+/* The bodies real forknote and node.js pools send, with just the bits we
+   read */
+func fakePoolBody(poolType string, path string, step FakePoolStep) (string,
+                                                                    bool) {
+    lastFound := step.LastFound
+
+    if lastFound == 0 {
+        lastFound = now().Unix()
+    }
+
+    switch {
+    case poolType == "forknote" && path == "/stats":
+        /* Forknote pools give it in milliseconds, as a string */
+        return fmt.Sprintf("{\"network\":{\"height\":%d},\"pool\":" +
+                           "{\"lastBlockFound\":\"%d000\"}}", step.Height,
+                           lastFound), true
+    case poolType == "node.js" && path == "/network/stats":
+        return fmt.Sprintf("{\"height\":%d}", step.Height), true
+    case poolType == "node.js" && path == "/pool/stats":
+        return fmt.Sprintf("{\"lastBlockFoundTime\":%d}", lastFound), true
+    }
+
+    return "", false
+}
+
+func (p *FakePool) serve(w http.ResponseWriter, r *http.Request) {
+    step := p.current()
+
+    if step.Latency != "" {
+        if d, err := time.ParseDuration(step.Latency); err == nil {
+            time.Sleep(d)
+        }
+    }
+
+    switch step.Fail {
+    case "status":
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    case "hang":
+        /* Until we give up on it, so closing the server doesn't wait */
+        select {
+        case <-r.Context().Done():
+        case <-time.After(fakeHangTime()):
+        }
+
+        return
+    case "close":
+        if hijacker, ok := w.(http.Hijacker); ok {
+            if conn, _, err := hijacker.Hijack(); err == nil {
+                conn.Close()
+                return
+            }
+        }
+
+        return
+    }
+
+    body, ok := fakePoolBody(p.script.Type, r.URL.Path, step)
+
+    if !ok {
+        http.NotFound(w, r)
+        return
+    }
+
+    if step.Fail == "garbage" {
+        body = "<html>502 Bad Gateway</html>"
+    }
+
+    encoded, err := fakeEncode(body, step.Encoding)
+
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    switch step.Encoding {
+    case "gzip", "deflate":
+        w.Header().Set("Content-Encoding", step.Encoding)
+    case "gzip-multi":
+        w.Header()["Content-Encoding"] = []string{" ", "gzip"}
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(encoded)
+}
+
+func fakeEncode(body string, encoding string) ([]byte, error) {
+    var buf bytes.Buffer
+
+    switch encoding {
+    case "":
+        return []byte(body), nil
+    case "gzip", "gzip-multi":
+        w := gzip.NewWriter(&buf)
+        w.Write([]byte(body))
+        w.Close()
+    case "deflate":
+        w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+
+        if err != nil {
+            return nil, err
+        }
+
+        w.Write([]byte(body))
+        w.Close()
+    default:
+        return nil, fmt.Errorf("Unknown encoding %s", encoding)
+    }
+
+    return buf.Bytes(), nil
+}
+
+/* Several fake pools, moved on a step at a time together */
+type FakePools struct {
+    pools       []*FakePool
+}
+
+func startFakePools(scripts []FakePoolScript) *FakePools {
+    f := &FakePools{}
+
+    for _, script := range scripts {
+        f.pools = append(f.pools, startFakePool(script))
+    }
+
+    return f
+}
+
+func (f *FakePools) poolInfos() []PoolInfo {
+    pools := make([]PoolInfo, 0)
+
+    for _, p := range f.pools {
+        pools = append(pools, p.poolInfo())
+    }
+
+    return pools
+}
+
+func (f *FakePools) advance() {
+    for _, p := range f.pools {
+        p.advance()
+    }
+}
+
+func (f *FakePools) close() {
+    for _, p := range f.pools {
+        p.close()
+    }
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+    "net"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+    "net/http"
+    "runtime/debug"
+    "github.com/bwmarrin/discordgo"
+)
+
+type HealthConfig struct {
+    /* How long since heightWatcher last finished a cycle before we're
+       unhealthy, e.g. "10m" */
+    MaxCycleAge     string `json:"maxCycleAge"`
+    /* How long since the pools list was last downloaded before we're not
+       ready, e.g. "3h" */
+    MaxPoolsAge     string `json:"maxPoolsAge"`
+}
+
+/* Checking every pool can take a while if lots of them are timing out */
+const defaultMaxCycleAge time.Duration = time.Minute * 10
+
+/* The list is updated hourly, so this allows a couple of failures */
+const defaultMaxPoolsAge time.Duration = time.Hour * 3
+
+type Health struct {
+    sync.Mutex
+    started             time.Time
+    gatewayConnected    bool
+    lastCycle           time.Time
+    poolsUpdated        time.Time
+}
+
+var health = Health{started: now()}
+
+/* Served by /healthz and /readyz */
+type HealthJSON struct {
+    Ok                  bool     `json:"ok"`
+    GatewayConnected    bool     `json:"gatewayConnected"`
+    /* Seconds since the last cycle finished, or since we started if none
+       has */
+    LastCycleAge        float64  `json:"lastCycleAge"`
+    /* Seconds since the pools list was downloaded, -1 if it never has
+       been */
+    PoolsListAge        float64  `json:"poolsListAge"`
+    Problems            []string `json:"problems,omitempty"`
+}
+
+func configDuration(value string, fallback time.Duration) time.Duration {
+    if value == "" {
+        return fallback
+    }
+
+    d, err := time.ParseDuration(value)
+
+    if err != nil {
+        logWarn("Invalid duration in config",
+                Fields{"value": value, "error": err})
+        return fallback
+    }
+
+    return d
+}
+
+func (h *Health) cycleFinished() {
+    h.Lock()
+    defer h.Unlock()
+
+    h.lastCycle = now()
+}
+
+func (h *Health) poolsListUpdated() {
+    h.Lock()
+    defer h.Unlock()
+
+    h.poolsUpdated = now()
+}
+
+func (h *Health) setGateway(connected bool) {
+    h.Lock()
+    defer h.Unlock()
+
+    h.gatewayConnected = connected
+}
+
+/* Liveness only cares that heightWatcher is still going. Readiness also
+   needs discord and a recent pools list */
+func (h *Health) check(ready bool) HealthJSON {
+    h.Lock()
+    defer h.Unlock()
+
+    lastCycle := h.lastCycle
+
+    if lastCycle.IsZero() {
+        lastCycle = h.started
+    }
+
+    result := HealthJSON{Ok: true, GatewayConnected: h.gatewayConnected,
+                         LastCycleAge: since(lastCycle).Seconds(),
+                         PoolsListAge: -1}
+
+    if !h.poolsUpdated.IsZero() {
+        result.PoolsListAge = since(h.poolsUpdated).Seconds()
+    }
+
+    maxCycleAge := configDuration(config.Health.MaxCycleAge,
+                                  defaultMaxCycleAge)
+    maxPoolsAge := configDuration(config.Health.MaxPoolsAge,
+                                  defaultMaxPoolsAge)
+
+    problems := make([]string, 0)
+
+    if since(lastCycle) > maxCycleAge {
+        problems = append(problems, "heightWatcher hasn't finished a " +
+                                    "cycle recently")
+    }
+
+    if ready {
+        if h.lastCycle.IsZero() {
+            problems = append(problems, "heightWatcher hasn't finished a " +
+                                        "cycle yet")
+        }
+
+        if !h.gatewayConnected {
+            problems = append(problems, "Not connected to the discord " +
+                                        "gateway")
+        }
+
+        if h.poolsUpdated.IsZero() ||
+           since(h.poolsUpdated) > maxPoolsAge {
+            problems = append(problems, "The pools list hasn't been " +
+                                        "updated recently")
+        }
+    }
+
+    result.Problems = problems
+    result.Ok = len(problems) == 0
+
+    return result
+}
+
+func serveHealth(w http.ResponseWriter, r *http.Request, ready bool) {
+    result := health.check(ready)
+
+    status := http.StatusOK
+
+    if !result.Ok {
+        status = http.StatusServiceUnavailable
+    }
+
+    serveJSON(w, r, status, result)
+}
+
+/* /healthz - is the bot still working, or should it be restarted */
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+    serveHealth(w, r, false)
+}
+
+/* /readyz - is the bot fully up and able to alert */
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+    serveHealth(w, r, true)
+}
+
+func gatewayConnected(s *discordgo.Session, c *discordgo.Connect) {
+    health.setGateway(true)
+    logInfo("Connected to the discord gateway", nil)
+}
+
+func gatewayDisconnected(s *discordgo.Session, d *discordgo.Disconnect) {
+    health.setGateway(false)
+    logWarn("Disconnected from the discord gateway", nil)
+}
+
+/* Runs f, logging rather than dying if it panics, so one bad cycle doesn't
+   take the whole bot down */
+func recoverPanics(where string, f func()) {
+    defer func() {
+        if r := recover(); r != nil {
+            logError("Recovered from panic",
+                     Fields{"where": where, "panic": r,
+                            "stack": string(debug.Stack())})
+        }
+    }()
+
+    f()
+}
+
+/* Tells systemd how we're doing, if it started us with Type=notify */
+func sdNotify(state string) {
+    socket := os.Getenv("NOTIFY_SOCKET")
+
+    if socket == "" {
+        return
+    }
+
+    conn, err := net.Dial("unixgram", socket)
+
+    if err != nil {
+        logWarn("Failed to notify systemd", Fields{"error": err})
+        return
+    }
+
+    defer conn.Close()
+
+    if _, err := conn.Write([]byte(state)); err != nil {
+        logWarn("Failed to notify systemd", Fields{"error": err})
+    }
+}
+
+/* If systemd has WatchdogSec set, ping it while we're healthy, so it
+   restarts us if heightWatcher gets stuck. Returns a function that stops
+   pinging it */
+func startWatchdog() func() {
+    usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+
+    if err != nil || usec <= 0 {
+        return func() {}
+    }
+
+    interval := time.Duration(usec) * time.Microsecond / 2
+
+    ticker := clock.NewTicker(interval)
+
+    stop := make(chan bool)
+    stopped := make(chan bool)
+
+    go func() {
+        defer close(stopped)
+        defer ticker.Stop()
+
+        for {
+            select {
+            case <-stop:
+                return
+            case <-ticker.C():
+                if health.check(false).Ok {
+                    sdNotify("WATCHDOG=1")
+                }
+            }
+        }
+    }()
+
+    return func() {
+        close(stop)
+        <-stopped
+    }
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+    "net"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestHealthCheck(t *testing.T) {
+    setupTest(t)
+
+    health = Health{started: now()}
+
+    if result := health.check(false); !result.Ok {
+        t.Errorf("Unhealthy before the first cycle is due: %v",
+                 result.Problems)
+    }
+
+    /* Not ready without a cycle, discord and a pools list */
+    if result := health.check(true); result.Ok || len(result.Problems) != 3 {
+        t.Errorf("Expected 3 problems, got %v", result.Problems)
+    }
+
+    health.cycleFinished()
+    health.poolsListUpdated()
+    health.setGateway(true)
+
+    clock.(*FakeClock).advance(time.Minute)
+
+    result := health.check(true)
+
+    if !result.Ok || result.LastCycleAge != 60 || result.PoolsListAge != 60 {
+        t.Errorf("Got %+v", result)
+    }
+
+    clock.(*FakeClock).advance(defaultMaxCycleAge)
+
+    if result := health.check(false); result.Ok {
+        t.Errorf("Healthy without a cycle for %s", defaultMaxCycleAge)
+    }
+}
+
+/* Listens where systemd would, returning what the bot tells it */
+func startFakeSystemd(t *testing.T) <-chan string {
+    dir, err := os.Getwd()
+
+    if err != nil {
+        t.Fatalf("Failed to get working dir: %s", err)
+    }
+
+    socket := filepath.Join(dir, "notify")
+
+    conn, err := net.ListenPacket("unixgram", socket)
+
+    if err != nil {
+        t.Fatalf("Failed to listen on %s: %s", socket, err)
+    }
+
+    t.Cleanup(func() { conn.Close() })
+
+    os.Setenv("NOTIFY_SOCKET", socket)
+    os.Setenv("WATCHDOG_USEC", "2000000")
+
+    t.Cleanup(func() {
+        os.Unsetenv("NOTIFY_SOCKET")
+        os.Unsetenv("WATCHDOG_USEC")
+    })
+
+    notified := make(chan string, 10)
+
+    go func() {
+        buf := make([]byte, 64)
+
+        for {
+            n, _, err := conn.ReadFrom(buf)
+
+            if err != nil {
+                return
+            }
+
+            notified <- string(buf[:n])
+        }
+    }()
+
+    return notified
+}
+
+func TestWatchdog(t *testing.T) {
+    setupTest(t)
+
+    health = Health{started: now()}
+    health.cycleFinished()
+
+    notified := startFakeSystemd(t)
+
+    stop := startWatchdog()
+    defer stop()
+
+    /* Pinged every half of WatchdogSec, on the bot's clock */
+    clock.(*FakeClock).advance(time.Second)
+
+    select {
+    case state := <-notified:
+        if state != "WATCHDOG=1" {
+            t.Errorf("Got %q", state)
+        }
+    case <-time.After(time.Second * 5):
+        t.Fatalf("systemd wasn't pinged")
+    }
+
+    /* heightWatcher is stuck, so let systemd restart us */
+    clock.(*FakeClock).advance(defaultMaxCycleAge + time.Minute)
+
+    select {
+    case state := <-notified:
+        t.Errorf("Pinged while unhealthy: %q", state)
+    case <-time.After(time.Millisecond * 200):
+    }
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "testing"
+    "time"
+    "net/http"
+    "encoding/json"
+    "io/ioutil"
+    "github.com/bwmarrin/discordgo"
+)
+
+/* The guild and users every test chat knows about */
+const testGuild string = "100"
+const testUser string = "1001"
+const testOtherUser string = "1002"
+const testAdmin string = "1003"
+const testAdminRole string = "2001"
+
+/* When the fake clock starts */
+var testStart = time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+/* Puts everything back to how the bot starts, in a directory of its own so
+   the state files don't touch the real ones, and returns a chat with the
+   pools and bots channels in it */
+func setupTest(t *testing.T) *FakeChat {
+    dir, err := ioutil.TempDir("", "poolbot")
+
+    if err != nil {
+        t.Fatalf("Failed to make temp dir: %s", err)
+    }
+
+    old, err := os.Getwd()
+
+    if err != nil {
+        t.Fatalf("Failed to get working dir: %s", err)
+    }
+
+    if err := os.Chdir(dir); err != nil {
+        t.Fatalf("Failed to change dir: %s", err)
+    }
+
+    t.Cleanup(func() {
+        os.Chdir(old)
+        os.RemoveAll(dir)
+    })
+
+    logger.out = ioutil.Discard
+
+    config = Config{DiscordFormat: discordFormatCode}
+    config.Permissions.Admin = []string{testAdminRole}
+
+    globalInfo = PoolsInfo{}
+    clock = newFakeClock(testStart)
+    fetchApi = fetchApiLive
+    archive.out = nil
+    incidents = IncidentLog{}
+    extraNotifiers = nil
+    statusBoardMessages = nil
+    poolsCache = PoolsCache{}
+    pendingEmails = PendingEmails{codes: make(map[string]PendingEmail)}
+    pendingClaims = PendingClaims{claims: make(map[string]PendingClaim)}
+    rateLimiter = RateLimiter {
+        buckets: make(map[string]*TokenBucket),
+        recent: make(map[string]RecentReply),
+    }
+
+    chat := newFakeChat("bot")
+
+    chat.addChannel(poolsChannel, testGuild)
+    chat.addChannel(botsChannel, testGuild)
+    chat.addChannel("other", testGuild)
+    chat.addMember(testGuild, testUser, nil)
+    chat.addMember(testGuild, testOtherUser, nil)
+    chat.addMember(testGuild, testAdmin, []string{testAdminRole})
+
+    return chat
+}
+
+/* Types a text command as the user, returning what the bot sent back */
+func say(chat *FakeChat, userID string, channelID string,
+         content string) []FakeMessage {
+    before := len(chat.messages())
+
+    handleMessage(chat, &discordgo.Message {
+        Author: &discordgo.User{ID: userID},
+        ChannelID: channelID,
+        Content: content,
+    })
+
+    /* Some replies wait for an email to be sent */
+    emailsQueued.Wait()
+
+    return chat.messages()[before:]
+}
+
+/* Everything the messages said, as one string */
+func allText(messages []FakeMessage) string {
+    texts := make([]string, 0)
+
+    for _, m := range messages {
+        texts = append(texts, fakeMessageText(m))
+    }
+
+    return strings.Join(texts, "\n")
+}
+
+/* The messages sent to one channel */
+func sentTo(messages []FakeMessage, channelID string) []FakeMessage {
+    found := make([]FakeMessage, 0)
+
+    for _, m := range messages {
+        if m.channelID == channelID {
+            found = append(found, m)
+        }
+    }
+
+    return found
+}
+
+func expectText(t *testing.T, messages []FakeMessage, expected string) {
+    t.Helper()
+
+    if text := allText(messages); !strings.Contains(text, expected) {
+        t.Errorf("Expected %q in:\n%s", expected, text)
+    }
+}
+
+func expectNoText(t *testing.T, messages []FakeMessage, unexpected string) {
+    t.Helper()
+
+    if text := allText(messages); strings.Contains(text, unexpected) {
+        t.Errorf("Didn't expect %q in:\n%s", unexpected, text)
+    }
+}
+
+/* A pool as updatePools would have made it */
+func testPool(url string, height int) PoolInfo {
+    return PoolInfo{url: url, api: "https://" + url + "/api/",
+                    poolType: "forknote", height: height,
+                    timeLastFound: testStart}
+}
+
+/* Uses a local pools list with these forknote pools, instead of the real
+   one */
+func writePoolsList(t *testing.T, urls ...string) {
+    var pools Pools
+
+    for _, url := range urls {
+        pools.Pools = append(pools.Pools, Pool{Url: url, Type: "forknote",
+                                               Api: "https://" + url +
+                                                    "/api/"})
+    }
+
+    body, err := json.Marshal(pools)
+
+    if err != nil {
+        t.Fatalf("Failed to encode pools list: %s", err)
+    }
+
+    if err := ioutil.WriteFile("pools.json", body, 0644); err != nil {
+        t.Fatalf("Failed to write pools list: %s", err)
+    }
+
+    config.PoolSources = []string{"pools.json"}
+}
+
+/* Answers the pool APIs with these heights, instead of asking the pools.
+   Pools not listed are down */
+func stubPoolApis(heights map[string]int) {
+    fetchApi = func(apiURL string) (*ApiResponse, error) {
+        for url, height := range heights {
+            if apiURL != "https://" + url + "/api/stats" {
+                continue
+            }
+
+            body := fmt.Sprintf("{\"network\":{\"height\":%d},\"pool\":" +
+                                "{\"lastBlockFound\":\"%d000\"}}", height,
+                                now().Unix())
+
+            return &ApiResponse{Status: http.StatusOK, Header: http.Header{},
+                                Body: []byte(body)}, nil
+        }
+
+        return nil, fetchError("connection", apiURL,
+                               errors.New("connection refused"))
+    }
+}
+
+/* A slash command, as discord would send it */
+func testInteraction(userID string, channelID string) *discordgo.Interaction {
+    return &discordgo.Interaction {
+        ID: "interaction-" + userID,
+        Type: discordgo.InteractionApplicationCommand,
+        ChannelID: channelID,
+        GuildID: testGuild,
+        Member: &discordgo.Member{User: &discordgo.User{ID: userID}},
+    }
+}
+
+/* Waits for the chat to have sent at least n messages */
+func waitForMessages(t *testing.T, chat *FakeChat, n int) []FakeMessage {
+    t.Helper()
+
+    for i := 0; i < 500; i++ {
+        if messages := chat.messages(); len(messages) >= n {
+            return messages
+        }
+
+        time.Sleep(time.Millisecond * 10)
+    }
+
+    t.Fatalf("Timed out waiting for %d messages, got %d", n,
+             len(chat.messages()))
+
+    return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+    "sync"
+    "time"
+)
+
+/* How far back the dashboard charts go. At one sample a cycle, this is
+   a few thousand samples a pool */
+const historyLength time.Duration = time.Hour * 24
+
+/* A pool at the end of one cycle */
+type Sample struct {
+    Time        time.Time `json:"time"`
+    Height      int       `json:"height"`
+    /* How many blocks it was ahead of the median, 0 if it was down */
+    Deviation   int       `json:"deviation"`
+    Status      string    `json:"status"`
+}
+
+/* Served by /api/pools/{name}/history */
+type PoolHistory struct {
+    /* The percentage of samples the pool was Ok in */
+    Uptime      float64   `json:"uptime"`
+    Samples     []Sample  `json:"samples"`
+}
+
+/* Every pools samples, oldest first. Only kept in memory, so the charts
+   start again when the bot restarts */
+type HistoryStore struct {
+    sync.RWMutex
+    /* Keyed by pool url */
+    pools       map[string][]Sample
+}
+
+var history = HistoryStore {
+    pools: make(map[string][]Sample),
+}
+
+/* Called after each heightWatcher cycle */
+func (h *HistoryStore) record() {
+    h.Lock()
+    defer h.Unlock()
+
+    now := clock.Now()
+    known := make(map[string]bool)
+
+    for index, _ := range globalInfo.pools {
+        v := &globalInfo.pools[index]
+
+        sample := Sample{Time: now, Height: v.height, Status: poolStatus(v)}
+
+        if v.height != 0 {
+            sample.Deviation = v.height - globalInfo.modeHeight
+        }
+
+        samples := append(h.pools[v.url], sample)
+
+        /* Drop the samples that are too old */
+        for len(samples) != 0 && now.Sub(samples[0].Time) > historyLength {
+            samples = samples[1:]
+        }
+
+        h.pools[v.url] = samples
+        known[v.url] = true
+    }
+
+    /* Pools that have been taken off the list */
+    for url, _ := range h.pools {
+        if !known[url] {
+            delete(h.pools, url)
+        }
+    }
+}
+
+func (h *HistoryStore) pool(url string) (PoolHistory, bool) {
+    h.RLock()
+    defer h.RUnlock()
+
+    samples, ok := h.pools[url]
+
+    if !ok {
+        return PoolHistory{}, false
+    }
+
+    up := 0
+
+    for _, sample := range samples {
+        if sample.Status == "Ok" {
+            up++
+        }
+    }
+
+    result := PoolHistory{Samples: make([]Sample, len(samples))}
+
+    copy(result.Samples, samples)
+
+    if len(samples) != 0 {
+        result.Uptime = float64(up) / float64(len(samples)) * 100
+    }
+
+    return result, true
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "os"
+    "sync"
+    "time"
+    "encoding/json"
+    "io/ioutil"
+)
+
+/* Where we keep the history of pools going down and the chain getting
+   stuck, so it survives restarts */
+const incidentsFile string = "incidents.json"
+
+/* Only keep this many, dropping the oldest */
+const maxIncidents = 500
+
+/* The chain stopped finding blocks. The other kinds are the watch events */
+const incidentStuck = "stuck"
+
+type Incident struct {
+    /* Empty for the chain getting stuck */
+    Pool        string     `json:"pool,omitempty"`
+    Kind        string     `json:"kind"`
+    Started     time.Time  `json:"started"`
+    /* Nil if it is still going on */
+    Ended       *time.Time `json:"ended,omitempty"`
+}
+
+type IncidentLog struct {
+    sync.Mutex
+    incidents   []Incident
+    /* Where to save them. Empty to only keep them in memory */
+    file        string
+}
+
+var incidents = IncidentLog{file: incidentsFile}
+
+func getIncidents() ([]Incident, error) {
+    list := make([]Incident, 0)
+
+    /* File exists */
+    if _, err := os.Stat(incidentsFile); err == nil {
+        body, err := ioutil.ReadFile(incidentsFile)
+
+        if err != nil {
+            logError("Failed to read incidents",
+                     Fields{"file": incidentsFile, "error": err})
+            return list, err
+        }
+
+        if err := json.Unmarshal(body, &list); err != nil {
+            logError("Failed to parse incidents",
+                     Fields{"file": incidentsFile, "error": err})
+            return list, err
+        }
+
+        now := clock.Now()
+
+        /* We don't remember what was wrong before restarting, so we can't
+           tell when these end. They get started again if they're still
+           going on */
+        for i, _ := range list {
+            if list[i].Ended == nil {
+                list[i].Ended = &now
+            }
+        }
+    }
+
+    return list, nil
+}
+
+/* Must be called with the log locked */
+func (l *IncidentLog) write() {
+    if l.file == "" {
+        return
+    }
+
+    body, err := json.MarshalIndent(l.incidents, "", "    ")
+
+    if err != nil {
+        logError("Failed to encode incidents", Fields{"error": err})
+        return
+    }
+
+    if err := ioutil.WriteFile(l.file, body, 0644); err != nil {
+        logError("Failed to write incidents",
+                 Fields{"file": l.file, "error": err})
+    }
+}
+
+func (l *IncidentLog) start(pool string, kind string) {
+    l.Lock()
+    defer l.Unlock()
+
+    l.incidents = append(l.incidents, Incident{Pool: pool, Kind: kind,
+                                               Started: now()})
+
+    if len(l.incidents) > maxIncidents {
+        l.incidents = l.incidents[len(l.incidents) - maxIncidents:]
+    }
+
+    l.write()
+}
+
+/* Ends any incidents of these kinds still going on for the pool */
+func (l *IncidentLog) end(pool string, kinds ...string) {
+    l.Lock()
+    defer l.Unlock()
+
+    now := clock.Now()
+
+    for i, _ := range l.incidents {
+        incident := &l.incidents[i]
+
+        if incident.Pool == pool && incident.Ended == nil &&
+           elem(incident.Kind, kinds) {
+            incident.Ended = &now
+        }
+    }
+
+    l.write()
+}
+
+/* A copy of the incidents, newest first */
+func (l *IncidentLog) list() []Incident {
+    l.Lock()
+    defer l.Unlock()
+
+    list := make([]Incident, 0)
+
+    for i := len(l.incidents) - 1; i >= 0; i-- {
+        list = append(list, l.incidents[i])
+    }
+
+    return list
+}
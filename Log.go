@@ -0,0 +1,254 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "encoding/json"
+)
+
+type LogLevel int
+
+const (
+    logLevelDebug LogLevel = iota
+    logLevelInfo
+    logLevelWarn
+    logLevelError
+)
+
+func (l LogLevel) String() string {
+    switch l {
+    case logLevelDebug:
+        return "debug"
+    case logLevelWarn:
+        return "warn"
+    case logLevelError:
+        return "error"
+    default:
+        return "info"
+    }
+}
+
+func parseLogLevel(level string) (LogLevel, error) {
+    for _, l := range []LogLevel{logLevelDebug, logLevelInfo, logLevelWarn,
+                                 logLevelError} {
+        if strings.ToLower(level) == l.String() {
+            return l, nil
+        }
+    }
+
+    return logLevelInfo, fmt.Errorf("Unknown log level %s", level)
+}
+
+type LogConfig struct {
+    /* debug, info (the default), warn or error */
+    Level       string `json:"level"`
+    /* "text" (the default), or "json" for one JSON object a line */
+    Format      string `json:"format"`
+    /* Log to this file instead of stdout */
+    File        string `json:"file"`
+    /* Start a new file once it gets this big. Defaults to 10 */
+    MaxSizeMB   int    `json:"maxSizeMB"`
+    /* How many old files to keep, as file.1, file.2... Defaults to 5 */
+    MaxFiles    int    `json:"maxFiles"`
+}
+
+/* Extra context for a log line, e.g. the pool and url */
+type Fields map[string]interface{}
+
+type Logger struct {
+    sync.Mutex
+    level       LogLevel
+    json        bool
+    out         io.Writer
+}
+
+/* Until the config is read, log everything useful to stdout */
+var logger = &Logger{level: logLevelInfo, out: os.Stdout}
+
+func setupLogger(c LogConfig) error {
+    level := logLevelInfo
+
+    if c.Level != "" {
+        l, err := parseLogLevel(c.Level)
+
+        if err != nil {
+            return err
+        }
+
+        level = l
+    }
+
+    if c.Format != "" && c.Format != "text" && c.Format != "json" {
+        return fmt.Errorf("Unknown log format %s", c.Format)
+    }
+
+    var out io.Writer = os.Stdout
+
+    if c.File != "" {
+        file, err := openRotatingFile(c)
+
+        if err != nil {
+            return err
+        }
+
+        out = file
+    }
+
+    logger.Lock()
+    defer logger.Unlock()
+
+    logger.level = level
+    logger.json = c.Format == "json"
+    logger.out = out
+
+    return nil
+}
+
+func (l *Logger) log(level LogLevel, msg string, fields Fields) {
+    l.Lock()
+    defer l.Unlock()
+
+    if level < l.level {
+        return
+    }
+
+    now := time.Now().UTC().Format(time.RFC3339)
+
+    if l.json {
+        entry := map[string]interface{}{"time": now, "level": level.String(),
+                                        "msg": msg}
+
+        for k, v := range fields {
+            /* Errors encode as {} otherwise */
+            if err, ok := v.(error); ok {
+                v = err.Error()
+            }
+
+            entry[k] = v
+        }
+
+        body, _ := json.Marshal(entry)
+
+        l.out.Write(append(body, '\n'))
+        return
+    }
+
+    line := fmt.Sprintf("%s %-5s %s", now, strings.ToUpper(level.String()),
+                        msg)
+
+    keys := make([]string, 0)
+
+    for k, _ := range fields {
+        keys = append(keys, k)
+    }
+
+    sort.Strings(keys)
+
+    for _, k := range keys {
+        value := fmt.Sprint(fields[k])
+
+        if strings.ContainsAny(value, " \"=") {
+            value = fmt.Sprintf("%q", value)
+        }
+
+        line += fmt.Sprintf(" %s=%s", k, value)
+    }
+
+    fmt.Fprintln(l.out, line)
+}
+
+func logDebug(msg string, fields Fields) {
+    logger.log(logLevelDebug, msg, fields)
+}
+
+func logInfo(msg string, fields Fields) {
+    logger.log(logLevelInfo, msg, fields)
+}
+
+func logWarn(msg string, fields Fields) {
+    logger.log(logLevelWarn, msg, fields)
+}
+
+func logError(msg string, fields Fields) {
+    logger.log(logLevelError, msg, fields)
+}
+
+/* A log file that moves itself to file.1 once it gets too big, and file.1
+   to file.2 and so on, deleting the oldest */
+type RotatingFile struct {
+    path        string
+    maxSize     int64
+    maxFiles    int
+    file        *os.File
+    size        int64
+}
+
+func openRotatingFile(c LogConfig) (*RotatingFile, error) {
+    r := &RotatingFile{path: c.File, maxSize: int64(c.MaxSizeMB) << 20,
+                       maxFiles: c.MaxFiles}
+
+    if r.maxSize <= 0 {
+        r.maxSize = 10 << 20
+    }
+
+    if r.maxFiles <= 0 {
+        r.maxFiles = 5
+    }
+
+    return r, r.open()
+}
+
+func (r *RotatingFile) open() error {
+    file, err := os.OpenFile(r.path, os.O_CREATE | os.O_WRONLY | os.O_APPEND,
+                             0644)
+
+    if err != nil {
+        return err
+    }
+
+    info, err := file.Stat()
+
+    if err != nil {
+        file.Close()
+        return err
+    }
+
+    r.file = file
+    r.size = info.Size()
+
+    return nil
+}
+
+func (r *RotatingFile) rotate() error {
+    r.file.Close()
+
+    for i := r.maxFiles - 1; i >= 1; i-- {
+        os.Rename(fmt.Sprintf("%s.%d", r.path, i),
+                  fmt.Sprintf("%s.%d", r.path, i + 1))
+    }
+
+    os.Rename(r.path, r.path + ".1")
+
+    return r.open()
+}
+
+/* Only called with the logger locked */
+func (r *RotatingFile) Write(p []byte) (int, error) {
+    if r.size + int64(len(p)) > r.maxSize && r.size != 0 {
+        if err := r.rotate(); err != nil {
+            fmt.Fprintln(os.Stderr, "Failed to rotate log file! Error:", err)
+            return 0, err
+        }
+    }
+
+    n, err := r.file.Write(p)
+
+    r.size += int64(n)
+
+    return n, err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "sort"
+)
+
+/* How many edits away a pool name can be and still be suggested */
+const maxSuggestionDistance = 3
+
+/* How many pools we list when a name is ambiguous */
+const maxSuggestions = 5
+
+/* Strips the bits people add when pasting a pool address, so
+   https://TurtlePool.space/ matches turtlepool.space */
+func normalizePool(name string) string {
+    name = strings.ToLower(strings.TrimSpace(name))
+    name = strings.TrimPrefix(name, "https://")
+    name = strings.TrimPrefix(name, "http://")
+    name = strings.TrimSuffix(name, "/")
+
+    return name
+}
+
+func findPool(url string) *PoolInfo {
+    url = normalizePool(url)
+
+    for index, _ := range globalInfo.pools {
+        if normalizePool(globalInfo.pools[index].url) == url {
+            return &globalInfo.pools[index]
+        }
+    }
+
+    return nil
+}
+
+/* Finds the pool the user meant, trying an exact match, then a prefix,
+   then anywhere in the name. If there isn't exactly one match, tells the
+   user what they might have meant, and returns nil */
+func resolvePool(c *CommandContext, query string) *PoolInfo {
+    if v := findPool(query); v != nil {
+        return v
+    }
+
+    name := normalizePool(query)
+
+    matchers := []func(url string) bool {
+        func(url string) bool { return strings.HasPrefix(url, name) },
+        /* Allow www.pool.com to be found with pool.com and vice versa */
+        func(url string) bool {
+            return strings.HasPrefix(strings.TrimPrefix(url, "www."),
+                                     strings.TrimPrefix(name, "www."))
+        },
+        func(url string) bool { return strings.Contains(url, name) },
+    }
+
+    for _, matches := range matchers {
+        found := make([]*PoolInfo, 0)
+
+        for index, _ := range globalInfo.pools {
+            v := &globalInfo.pools[index]
+
+            if name != "" && matches(normalizePool(v.url)) {
+                found = append(found, v)
+            }
+        }
+
+        if len(found) == 1 {
+            return found[0]
+        }
+
+        if len(found) > 1 {
+            names := make([]string, 0)
+
+            for _, v := range found {
+                names = append(names, v.url)
+            }
+
+            replyAmbiguousPool(c, query, names)
+            return nil
+        }
+    }
+
+    replyUnknownPool(c, query, suggestPools(name))
+
+    return nil
+}
+
+/* The pools with names close to what was typed, closest first */
+func suggestPools(name string) []string {
+    type suggestion struct {
+        url         string
+        distance    int
+    }
+
+    suggestions := make([]suggestion, 0)
+
+    for _, v := range globalInfo.pools {
+        url := normalizePool(v.url)
+
+        /* Compare against the name without the TLD as well, people often
+           leave it off */
+        distance := levenshtein(name, url)
+
+        if i := strings.LastIndex(url, "."); i != -1 {
+            if d := levenshtein(name, url[:i]); d < distance {
+                distance = d
+            }
+        }
+
+        if distance <= maxSuggestionDistance {
+            suggestions = append(suggestions, suggestion{v.url, distance})
+        }
+    }
+
+    sort.SliceStable(suggestions, func(i, j int) bool {
+        return suggestions[i].distance < suggestions[j].distance
+    })
+
+    names := make([]string, 0)
+
+    for i := 0; i < len(suggestions) && i < maxSuggestions; i++ {
+        names = append(names, suggestions[i].url)
+    }
+
+    return names
+}
+
+func replyUnknownPool(c *CommandContext, pool string, suggestions []string) {
+    msg := fmt.Sprintf("Couldn't find pool %s", pool)
+
+    if len(suggestions) != 0 {
+        msg += fmt.Sprintf(" - did you mean %s?",
+                           strings.Join(suggestions, ", "))
+    } else {
+        msg += fmt.Sprintf(" - type `%sheights` to view all known pools.",
+                           commandPrefix())
+    }
+
+    c.replyPrivate(msg)
+}
+
+func replyAmbiguousPool(c *CommandContext, pool string, matches []string) {
+    msg := fmt.Sprintf("%s matches more than one pool: ", pool)
+
+    if len(matches) > maxSuggestions {
+        msg += strings.Join(matches[:maxSuggestions], ", ") +
+               fmt.Sprintf(" and %d more", len(matches) - maxSuggestions)
+    } else {
+        msg += strings.Join(matches, ", ")
+    }
+
+    c.replyPrivate(msg + ". Which one did you mean?")
+}
+
+/* The number of single character edits to turn a into b */
+func levenshtein(a string, b string) int {
+    previous := make([]int, len(b) + 1)
+    current := make([]int, len(b) + 1)
+
+    for j := range previous {
+        previous[j] = j
+    }
+
+    for i := 1; i <= len(a); i++ {
+        current[0] = i
+
+        for j := 1; j <= len(b); j++ {
+            cost := 1
+
+            if a[i - 1] == b[j - 1] {
+                cost = 0
+            }
+
+            /* Deletion, insertion or substitution, whichever is cheapest */
+            current[j] = previous[j] + 1
+
+            if current[j - 1] + 1 < current[j] {
+                current[j] = current[j - 1] + 1
+            }
+
+            if previous[j - 1] + cost < current[j] {
+                current[j] = previous[j - 1] + cost
+            }
+        }
+
+        previous, current = current, previous
+    }
+
+    return previous[len(b)]
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+    "fmt"
+    "net"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* Why downloading a pools stats failed, so the metrics can count each kind
+   separately and the logs can say which url it was */
+type FetchError struct {
+    kind        string
+    url         string
+    err         error
+}
+
+func (e *FetchError) Error() string {
+    return e.err.Error()
+}
+
+func fetchError(kind string, url string, err error) error {
+    return &FetchError{kind: kind, url: url, err: err}
+}
+
+func fetchErrorKind(err error) string {
+    if e, ok := err.(*FetchError); ok {
+        return e.kind
+    }
+
+    return "other"
+}
+
+/* The url that failed, or the fallback if we don't know */
+func fetchErrorURL(err error, fallback string) string {
+    if e, ok := err.(*FetchError); ok {
+        return e.url
+    }
+
+    return fallback
+}
+
+func connectionErrorKind(err error) string {
+    if e, ok := err.(net.Error); ok && e.Timeout() {
+        return "timeout"
+    }
+
+    return "connection"
+}
+
+/* What we last saw of a pool */
+type PoolMetrics struct {
+    height              int
+    status              string
+    deviation           int
+    timeLastFound       time.Time
+    latency             time.Duration
+    /* Keyed by error kind */
+    errors              map[string]int
+}
+
+/* A copy of the bot's view of the network, taken after each cycle. The
+   HTTP server reads this rather than globalInfo, which the cycle is busy
+   changing */
+type Metrics struct {
+    sync.Mutex
+    /* Keyed by pool url */
+    pools               map[string]*PoolMetrics
+    modeHeight          int
+    heightLastUpdated   time.Time
+    cycleDuration       time.Duration
+    cycles              int
+    discordSendFailures int
+}
+
+var metrics = Metrics {
+    pools: make(map[string]*PoolMetrics),
+}
+
+func (m *Metrics) pool(url string) *PoolMetrics {
+    p, ok := m.pools[url]
+
+    if !ok {
+        p = &PoolMetrics{errors: make(map[string]int)}
+        m.pools[url] = p
+    }
+
+    return p
+}
+
+/* Called after fetching each pool */
+func (m *Metrics) recordFetch(url string, latency time.Duration, err error) {
+    m.Lock()
+    defer m.Unlock()
+
+    p := m.pool(url)
+
+    p.latency = latency
+
+    if err != nil {
+        p.errors[fetchErrorKind(err)]++
+    }
+}
+
+/* Called after each heightWatcher cycle */
+func (m *Metrics) recordCycle(duration time.Duration) {
+    m.Lock()
+    defer m.Unlock()
+
+    known := make(map[string]bool)
+
+    for index, _ := range globalInfo.pools {
+        v := &globalInfo.pools[index]
+
+        p := m.pool(v.url)
+
+        p.height = v.height
+        p.status = poolStatus(v)
+        p.deviation = v.height - globalInfo.modeHeight
+        p.timeLastFound = v.timeLastFound
+
+        known[v.url] = true
+    }
+
+    /* Pools that have been taken off the list */
+    for url, _ := range m.pools {
+        if !known[url] {
+            delete(m.pools, url)
+        }
+    }
+
+    m.modeHeight = globalInfo.modeHeight
+    m.heightLastUpdated = globalInfo.heightLastUpdated
+    m.cycleDuration = duration
+    m.cycles++
+}
+
+func (m *Metrics) discordSendFailed() {
+    m.Lock()
+    defer m.Unlock()
+
+    m.discordSendFailures++
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"",
+                                       "\n", "\\n")
+
+/* Builds the metrics in the prometheus text format */
+type MetricsWriter struct {
+    builder             strings.Builder
+}
+
+func (w *MetricsWriter) describe(name string, kind string, help string) {
+    fmt.Fprintf(&w.builder, "# HELP %s %s\n# TYPE %s %s\n", name, help, name,
+                kind)
+}
+
+func (w *MetricsWriter) value(name string, labels []string, value float64) {
+    w.builder.WriteString(name)
+
+    if len(labels) != 0 {
+        pairs := make([]string, 0)
+
+        for i := 0; i + 1 < len(labels); i += 2 {
+            value := labelEscaper.Replace(labels[i + 1])
+
+            pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], value))
+        }
+
+        w.builder.WriteString("{" + strings.Join(pairs, ",") + "}")
+    }
+
+    fmt.Fprintf(&w.builder, " %g\n", value)
+}
+
+/* The statuses a pool can be in, and their prometheus label values */
+var metricStatuses = []string{"Ok", "Api Down", "Forked"}
+
+func statusLabel(status string) string {
+    return strings.Replace(strings.ToLower(status), " ", "_", -1)
+}
+
+func (m *Metrics) write() string {
+    m.Lock()
+    defer m.Unlock()
+
+    w := &MetricsWriter{}
+
+    urls := make([]string, 0)
+
+    for url, _ := range m.pools {
+        urls = append(urls, url)
+    }
+
+    sort.Strings(urls)
+
+    w.describe("poolbot_pool_height", "gauge",
+               "The height the pool API last reported, 0 if it is down")
+
+    for _, url := range urls {
+        w.value("poolbot_pool_height", []string{"pool", url},
+                float64(m.pools[url].height))
+    }
+
+    w.describe("poolbot_pool_status", "gauge",
+               "1 for the status the pool is in, 0 for the others")
+
+    for _, url := range urls {
+        for _, status := range metricStatuses {
+            value := 0.0
+
+            if m.pools[url].status == status {
+                value = 1
+            }
+
+            w.value("poolbot_pool_status", []string{"pool", url,
+                                                    "status",
+                                                    statusLabel(status)},
+                    value)
+        }
+    }
+
+    w.describe("poolbot_pool_height_deviation", "gauge",
+               "How many blocks the pool is ahead of the median height")
+
+    for _, url := range urls {
+        /* A down pool has no height to compare */
+        if m.pools[url].height == 0 {
+            continue
+        }
+
+        w.value("poolbot_pool_height_deviation", []string{"pool", url},
+                float64(m.pools[url].deviation))
+    }
+
+    w.describe("poolbot_pool_last_found_seconds", "gauge",
+               "Seconds since the pool last found a block")
+
+    for _, url := range urls {
+        /* Never found one, or we never heard */
+        if m.pools[url].timeLastFound.IsZero() {
+            continue
+        }
+
+        w.value("poolbot_pool_last_found_seconds", []string{"pool", url},
+                since(m.pools[url].timeLastFound).Seconds())
+    }
+
+    w.describe("poolbot_pool_api_latency_seconds", "gauge",
+               "How long fetching the pool stats last took")
+
+    for _, url := range urls {
+        w.value("poolbot_pool_api_latency_seconds", []string{"pool", url},
+                m.pools[url].latency.Seconds())
+    }
+
+    w.describe("poolbot_pool_fetch_errors_total", "counter",
+               "Failed fetches of the pool stats, by why they failed")
+
+    for _, url := range urls {
+        kinds := make([]string, 0)
+
+        for kind, _ := range m.pools[url].errors {
+            kinds = append(kinds, kind)
+        }
+
+        sort.Strings(kinds)
+
+        for _, kind := range kinds {
+            w.value("poolbot_pool_fetch_errors_total",
+                    []string{"pool", url, "kind", kind},
+                    float64(m.pools[url].errors[kind]))
+        }
+    }
+
+    w.describe("poolbot_mode_height", "gauge", "The median pool height")
+    w.value("poolbot_mode_height", nil, float64(m.modeHeight))
+
+    if !m.heightLastUpdated.IsZero() {
+        w.describe("poolbot_mode_height_changed_seconds", "gauge",
+                   "Seconds since the median pool height last changed")
+        w.value("poolbot_mode_height_changed_seconds", nil,
+                since(m.heightLastUpdated).Seconds())
+    }
+
+    w.describe("poolbot_cycle_duration_seconds", "gauge",
+               "How long the last check of every pool took")
+    w.value("poolbot_cycle_duration_seconds", nil, m.cycleDuration.Seconds())
+
+    w.describe("poolbot_cycles_total", "counter",
+               "How many times every pool has been checked")
+    w.value("poolbot_cycles_total", nil, float64(m.cycles))
+
+    w.describe("poolbot_discord_send_failures_total", "counter",
+               "Messages we failed to send to discord")
+    w.value("poolbot_discord_send_failures_total", nil,
+            float64(m.discordSendFailures))
+
+    return w.builder.String()
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    fmt.Fprint(w, metrics.write())
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+    "github.com/bwmarrin/discordgo"
+    "fmt"
+    "strings"
+    "net/http"
+    "net/url"
+    "encoding/json"
+    "io/ioutil"
+    "bytes"
+    "errors"
+    "html"
+    "time"
+)
+
+/* Something we can send the fork and stuck chain alerts to */
+type Notifier interface {
+    Name() string
+    Notify(alert Alert) error
+}
+
+/* An alert, before it has been formatted for a specific backend */
+type Alert struct {
+    /* The text of the alert, without any markup */
+    text                string
+    /* Whether the text is a table that needs a fixed width font */
+    preformatted        bool
+    /* Discord user IDs of the people who want to be pinged about this */
+    pingees             []string
+    /* Email addresses that want to be told about this */
+    emailees            []string
+    /* How discord should show this, if embeds are enabled */
+    embeds              []*discordgo.MessageEmbed
+}
+
+/* How a backend should present preformatted alerts */
+const formatCode string = "code"
+const formatPlain string = "plain"
+
+/* The settings for a single non discord backend */
+type NotifierConfig struct {
+    /* "slack", "telegram" or "matrix" */
+    Type            string `json:"type"`
+    /* "code" (the default) to send tables in a fixed width block, or
+       "plain" to send them as normal text */
+    Format          string `json:"format"`
+
+    /* Slack - the incoming webhook URL */
+    Webhook         string `json:"webhook"`
+
+    /* Telegram - the bot token and chat to post in. ApiURL defaults to
+       https://api.telegram.org */
+    Token           string `json:"token"`
+    ChatID          string `json:"chatId"`
+
+    /* Matrix - the homeserver, e.g. https://matrix.org, the access token of
+       the bot account, and the room to post in */
+    Homeserver      string `json:"homeserver"`
+    AccessToken     string `json:"accessToken"`
+    RoomID          string `json:"roomId"`
+
+    /* Overrides the API location, for telegram */
+    ApiURL          string `json:"apiUrl"`
+}
+
+const telegramApi string = "https://api.telegram.org"
+
+/* Backends from config.json, filled in by setup() */
+var extraNotifiers []Notifier
+
+/* Every place an alert for the pools channel should go to */
+func getNotifiers(s ChatClient) []Notifier {
+    notifiers := []Notifier{&DiscordNotifier{session: s, channel: poolsChannel}}
+
+    return append(notifiers, extraNotifiers...)
+}
+
+func notifyAll(notifiers []Notifier, alert Alert) {
+    for _, n := range notifiers {
+        if err := n.Notify(alert); err != nil {
+            logWarn("Failed to send alert",
+                    Fields{"notifier": n.Name(), "error": err})
+        }
+    }
+}
+
+func makeNotifiers(configs []NotifierConfig) ([]Notifier, error) {
+    notifiers := make([]Notifier, 0)
+
+    for _, c := range configs {
+        if c.Format == "" {
+            c.Format = formatCode
+        }
+
+        if c.Format != formatCode && c.Format != formatPlain {
+            return nil, fmt.Errorf("Unknown notifier format %s", c.Format)
+        }
+
+        switch c.Type {
+        case "slack":
+            if c.Webhook == "" {
+                return nil, errors.New("Slack notifier needs a webhook")
+            }
+
+            notifiers = append(notifiers, &SlackNotifier{config: c})
+        case "telegram":
+            if c.Token == "" || c.ChatID == "" {
+                return nil, errors.New("Telegram notifier needs a token " +
+                                       "and chatId")
+            }
+
+            if c.ApiURL == "" {
+                c.ApiURL = telegramApi
+            }
+
+            notifiers = append(notifiers, &TelegramNotifier{config: c})
+        case "matrix":
+            if c.Homeserver == "" || c.AccessToken == "" || c.RoomID == "" {
+                return nil, errors.New("Matrix notifier needs a homeserver, " +
+                                       "accessToken and roomId")
+            }
+
+            notifiers = append(notifiers, &MatrixNotifier{config: c})
+        default:
+            return nil, fmt.Errorf("Unknown notifier type %s", c.Type)
+        }
+    }
+
+    if smtpEnabled() {
+        /* A typo here would send the alerts and codes in plain text */
+        switch config.Smtp.Security {
+        case "", "starttls", "tls", "none":
+        default:
+            return nil, fmt.Errorf("Unknown smtp security %s",
+                                   config.Smtp.Security)
+        }
+
+        notifiers = append(notifiers, &EmailNotifier{config: config.Smtp})
+    }
+
+    return notifiers, nil
+}
+
+/* Posts to a discord channel, pinging anyone who asked for it */
+type DiscordNotifier struct {
+    session     ChatClient
+    channel     string
+}
+
+func (d *DiscordNotifier) Name() string {
+    return "discord"
+}
+
+func (d *DiscordNotifier) Notify(alert Alert) error {
+    for _, msg := range discordMessages(alert) {
+        if _, err := d.session.ChannelMessageSendComplex(d.channel,
+                                                         msg); err != nil {
+            metrics.discordSendFailed()
+            return err
+        }
+    }
+
+    return nil
+}
+
+/* Formats an alert for discord, as embeds or a code block */
+func discordMessages(alert Alert) []*discordgo.MessageSend {
+    pings := ""
+
+    for _, owner := range alert.pingees {
+        pings += fmt.Sprintf("<@%s> ", owner)
+    }
+
+    if useEmbeds() && len(alert.embeds) != 0 {
+        return packEmbeds(pings, alert.embeds)
+    }
+
+    msg := alert.text
+
+    if alert.preformatted {
+        msg = "```" + msg + "```"
+    }
+
+    return []*discordgo.MessageSend{&discordgo.MessageSend{Content: msg + pings}}
+}
+
+/* Posts to a slack incoming webhook */
+type SlackNotifier struct {
+    config      NotifierConfig
+}
+
+func (n *SlackNotifier) Name() string {
+    return "slack"
+}
+
+func (n *SlackNotifier) Notify(alert Alert) error {
+    msg := alert.text
+
+    if alert.preformatted && n.config.Format == formatCode {
+        msg = "```" + msg + "```"
+    }
+
+    return postJSON(n.config.Webhook, map[string]string{"text": msg}, nil)
+}
+
+/* Posts to a telegram chat with the bot API */
+type TelegramNotifier struct {
+    config      NotifierConfig
+}
+
+func (n *TelegramNotifier) Name() string {
+    return "telegram"
+}
+
+func (n *TelegramNotifier) Notify(alert Alert) error {
+    message := map[string]string {
+        "chat_id": n.config.ChatID,
+        "text": alert.text,
+    }
+
+    if alert.preformatted && n.config.Format == formatCode {
+        message["text"] = "<pre>" + html.EscapeString(alert.text) + "</pre>"
+        message["parse_mode"] = "HTML"
+    }
+
+    apiURL := fmt.Sprintf("%s/bot%s/sendMessage",
+                          strings.TrimSuffix(n.config.ApiURL, "/"),
+                          n.config.Token)
+
+    return postJSON(apiURL, message, nil)
+}
+
+/* Posts to a matrix room with the client-server API */
+type MatrixNotifier struct {
+    config      NotifierConfig
+}
+
+func (n *MatrixNotifier) Name() string {
+    return "matrix"
+}
+
+func (n *MatrixNotifier) Notify(alert Alert) error {
+    message := map[string]string {
+        "msgtype": "m.text",
+        "body": alert.text,
+    }
+
+    if alert.preformatted && n.config.Format == formatCode {
+        message["format"] = "org.matrix.custom.html"
+        message["formatted_body"] = "<pre><code>" +
+                                    html.EscapeString(alert.text) +
+                                    "</code></pre>"
+    }
+
+    /* The transaction ID just has to be unique for this access token, so
+       the server can drop duplicates if we retry */
+    txnID := fmt.Sprintf("poolbot%d", time.Now().UnixNano())
+
+    apiURL := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/" +
+                          "m.room.message/%s",
+                          strings.TrimSuffix(n.config.Homeserver, "/"),
+                          url.PathEscape(n.config.RoomID), txnID)
+
+    headers := map[string]string {
+        "Authorization": "Bearer " + n.config.AccessToken,
+    }
+
+    return sendJSON("PUT", apiURL, message, headers)
+}
+
+func postJSON(apiURL string, payload interface{},
+              headers map[string]string) error {
+    return sendJSON("POST", apiURL, payload, headers)
+}
+
+func sendJSON(method string, apiURL string, payload interface{},
+              headers map[string]string) error {
+    body, err := json.Marshal(payload)
+
+    if err != nil {
+        return err
+    }
+
+    req, err := http.NewRequest(method, apiURL, bytes.NewReader(body))
+
+    if err != nil {
+        return err
+    }
+
+    req.Header.Set("Content-Type", "application/json")
+
+    for k, v := range headers {
+        req.Header.Set(k, v)
+    }
+
+    client := http.Client {
+        Timeout: time.Duration(8 * time.Second),
+    }
+
+    resp, err := client.Do(req)
+
+    if err != nil {
+        return err
+    }
+
+    defer resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        reply, _ := ioutil.ReadAll(resp.Body)
+        return fmt.Errorf("Got status %d: %s", resp.StatusCode,
+                          strings.TrimSpace(string(reply)))
+    }
+
+    return nil
+}
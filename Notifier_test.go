@@ -0,0 +1,307 @@
+package main
+
+import (
+    "log"
+    "strings"
+    "testing"
+    "net/http"
+    "net/http/httptest"
+    "encoding/json"
+    "io/ioutil"
+)
+
+/* What a notifier sent to the mock server */
+type CapturedRequest struct {
+    method      string
+    path        string
+    header      http.Header
+    body        map[string]string
+}
+
+/* A local stand in for the slack, telegram and matrix APIs, answering
+   every request with the status given */
+func startMockApi(t *testing.T, status int) (*httptest.Server,
+                                             *[]CapturedRequest) {
+    requests := make([]CapturedRequest, 0)
+
+    server := httptest.NewServer(http.HandlerFunc(
+        func(w http.ResponseWriter, r *http.Request) {
+            body, _ := ioutil.ReadAll(r.Body)
+
+            captured := CapturedRequest{method: r.Method, path: r.URL.Path,
+                                        header: r.Header,
+                                        body: make(map[string]string)}
+
+            if err := json.Unmarshal(body, &captured.body); err != nil {
+                t.Errorf("Request body isn't JSON: %s", body)
+            }
+
+            requests = append(requests, captured)
+
+            w.WriteHeader(status)
+        }))
+
+    return server, &requests
+}
+
+var tableAlert = Alert{text: "Pool      Height\na<b>      100",
+                       preformatted: true}
+
+func onlyRequest(t *testing.T, requests []CapturedRequest) CapturedRequest {
+    if len(requests) != 1 {
+        t.Fatalf("Expected 1 request, got %d", len(requests))
+    }
+
+    return requests[0]
+}
+
+func TestSlackNotifier(t *testing.T) {
+    server, requests := startMockApi(t, http.StatusOK)
+    defer server.Close()
+
+    n := &SlackNotifier{config: NotifierConfig{Type: "slack",
+                                               Webhook: server.URL + "/hook",
+                                               Format: formatCode}}
+
+    if err := n.Notify(tableAlert); err != nil {
+        t.Fatalf("Notify failed: %s", err)
+    }
+
+    r := onlyRequest(t, *requests)
+
+    if r.method != "POST" || r.path != "/hook" {
+        t.Errorf("Got %s %s, expected POST /hook", r.method, r.path)
+    }
+
+    if r.header.Get("Content-Type") != "application/json" {
+        t.Errorf("Got content type %s", r.header.Get("Content-Type"))
+    }
+
+    if r.body["text"] != "```" + tableAlert.text + "```" {
+        t.Errorf("Got text %q", r.body["text"])
+    }
+}
+
+func TestSlackNotifierPlain(t *testing.T) {
+    server, requests := startMockApi(t, http.StatusOK)
+    defer server.Close()
+
+    n := &SlackNotifier{config: NotifierConfig{Type: "slack",
+                                               Webhook: server.URL,
+                                               Format: formatPlain}}
+
+    if err := n.Notify(tableAlert); err != nil {
+        t.Fatalf("Notify failed: %s", err)
+    }
+
+    if r := onlyRequest(t, *requests); r.body["text"] != tableAlert.text {
+        t.Errorf("Got text %q", r.body["text"])
+    }
+}
+
+func TestTelegramNotifier(t *testing.T) {
+    server, requests := startMockApi(t, http.StatusOK)
+    defer server.Close()
+
+    n := &TelegramNotifier{config: NotifierConfig{Type: "telegram",
+                                                  Token: "123:abc",
+                                                  ChatID: "-1001",
+                                                  ApiURL: server.URL + "/",
+                                                  Format: formatCode}}
+
+    if err := n.Notify(tableAlert); err != nil {
+        t.Fatalf("Notify failed: %s", err)
+    }
+
+    r := onlyRequest(t, *requests)
+
+    if r.method != "POST" || r.path != "/bot123:abc/sendMessage" {
+        t.Errorf("Got %s %s, expected POST /bot123:abc/sendMessage",
+                 r.method, r.path)
+    }
+
+    if r.body["chat_id"] != "-1001" {
+        t.Errorf("Got chat_id %q", r.body["chat_id"])
+    }
+
+    if r.body["parse_mode"] != "HTML" {
+        t.Errorf("Got parse_mode %q", r.body["parse_mode"])
+    }
+
+    /* The table has to be escaped, or telegram rejects it */
+    expected := "<pre>Pool      Height\na&lt;b&gt;      100</pre>"
+
+    if r.body["text"] != expected {
+        t.Errorf("Got text %q, expected %q", r.body["text"], expected)
+    }
+}
+
+func TestTelegramNotifierPlain(t *testing.T) {
+    server, requests := startMockApi(t, http.StatusOK)
+    defer server.Close()
+
+    n := &TelegramNotifier{config: NotifierConfig{Type: "telegram",
+                                                  Token: "123:abc",
+                                                  ChatID: "-1001",
+                                                  ApiURL: server.URL,
+                                                  Format: formatPlain}}
+
+    if err := n.Notify(tableAlert); err != nil {
+        t.Fatalf("Notify failed: %s", err)
+    }
+
+    r := onlyRequest(t, *requests)
+
+    if _, ok := r.body["parse_mode"]; ok {
+        t.Errorf("Plain messages shouldn't set parse_mode")
+    }
+
+    if r.body["text"] != tableAlert.text {
+        t.Errorf("Got text %q", r.body["text"])
+    }
+}
+
+func TestMatrixNotifier(t *testing.T) {
+    server, requests := startMockApi(t, http.StatusOK)
+    defer server.Close()
+
+    n := &MatrixNotifier{config: NotifierConfig{Type: "matrix",
+                                                Homeserver: server.URL,
+                                                AccessToken: "secret",
+                                                RoomID: "!room:example.org",
+                                                Format: formatCode}}
+
+    if err := n.Notify(tableAlert); err != nil {
+        t.Fatalf("Notify failed: %s", err)
+    }
+
+    r := onlyRequest(t, *requests)
+
+    prefix := "/_matrix/client/v3/rooms/!room:example.org/send/" +
+              "m.room.message/"
+
+    if r.method != "PUT" || !strings.HasPrefix(r.path, prefix) ||
+       len(r.path) == len(prefix) {
+        t.Errorf("Got %s %s, expected PUT %s<txn>", r.method, r.path,
+                 prefix)
+    }
+
+    if r.header.Get("Authorization") != "Bearer secret" {
+        t.Errorf("Got authorization %q", r.header.Get("Authorization"))
+    }
+
+    if r.body["msgtype"] != "m.text" || r.body["body"] != tableAlert.text {
+        t.Errorf("Got msgtype %q, body %q", r.body["msgtype"],
+                 r.body["body"])
+    }
+
+    if r.body["format"] != "org.matrix.custom.html" {
+        t.Errorf("Got format %q", r.body["format"])
+    }
+
+    expected := "<pre><code>Pool      Height\na&lt;b&gt;      100" +
+                "</code></pre>"
+
+    if r.body["formatted_body"] != expected {
+        t.Errorf("Got formatted_body %q, expected %q",
+                 r.body["formatted_body"], expected)
+    }
+}
+
+func TestMatrixNotifierPlain(t *testing.T) {
+    server, requests := startMockApi(t, http.StatusOK)
+    defer server.Close()
+
+    n := &MatrixNotifier{config: NotifierConfig{Type: "matrix",
+                                                Homeserver: server.URL,
+                                                AccessToken: "secret",
+                                                RoomID: "!room:example.org",
+                                                Format: formatPlain}}
+
+    if err := n.Notify(tableAlert); err != nil {
+        t.Fatalf("Notify failed: %s", err)
+    }
+
+    r := onlyRequest(t, *requests)
+
+    if _, ok := r.body["formatted_body"]; ok {
+        t.Errorf("Plain messages shouldn't set formatted_body")
+    }
+}
+
+func TestNotifierErrorStatus(t *testing.T) {
+    server, _ := startMockApi(t, http.StatusForbidden)
+    defer server.Close()
+
+    n := &SlackNotifier{config: NotifierConfig{Type: "slack",
+                                               Webhook: server.URL,
+                                               Format: formatCode}}
+
+    if err := n.Notify(tableAlert); err == nil {
+        t.Errorf("Expected an error for a 403")
+    }
+}
+
+/* Fetching a pool API mustn't turn off TLS verification for the
+   notifiers, which send tokens */
+func TestNotifierVerifiesTLS(t *testing.T) {
+    server := httptest.NewUnstartedServer(http.HandlerFunc(
+        func(w http.ResponseWriter, r *http.Request) {
+            if r.Method == "POST" {
+                t.Errorf("Sent over an untrusted certificate")
+            }
+        }))
+
+    /* Clients that don't trust it are expected */
+    server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+    server.StartTLS()
+    defer server.Close()
+
+    fetchApiLive(server.URL)
+
+    n := &SlackNotifier{config: NotifierConfig{Type: "slack",
+                                               Webhook: server.URL,
+                                               Format: formatCode}}
+
+    if err := n.Notify(tableAlert); err == nil {
+        t.Errorf("Expected an error for an untrusted certificate")
+    }
+}
+
+func TestMakeNotifiers(t *testing.T) {
+    setupTest(t)
+
+    bad := [][]NotifierConfig {
+        {{Type: "slack"}},
+        {{Type: "telegram", Token: "abc"}},
+        {{Type: "matrix", Homeserver: "https://matrix.org"}},
+        {{Type: "irc"}},
+        {{Type: "slack", Webhook: "https://hooks", Format: "fancy"}},
+    }
+
+    for _, configs := range bad {
+        if _, err := makeNotifiers(configs); err == nil {
+            t.Errorf("Expected an error for %+v", configs[0])
+        }
+    }
+
+    notifiers, err := makeNotifiers([]NotifierConfig {
+        {Type: "slack", Webhook: "https://hooks"},
+        {Type: "telegram", Token: "abc", ChatID: "1"},
+    })
+
+    if err != nil {
+        t.Fatalf("makeNotifiers failed: %s", err)
+    }
+
+    if len(notifiers) != 2 {
+        t.Fatalf("Expected 2 notifiers, got %d", len(notifiers))
+    }
+
+    telegram := notifiers[1].(*TelegramNotifier)
+
+    if telegram.config.ApiURL != telegramApi ||
+       telegram.config.Format != formatCode {
+        t.Errorf("Defaults not filled in: %+v", telegram.config)
+    }
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "strconv"
+    "sync"
+    "time"
+    "net/http"
+    "net/url"
+    "encoding/json"
+    "encoding/hex"
+    "io/ioutil"
+    "crypto/rand"
+)
+
+/* Where we store the verified operators of each pool, and the settings they
+   have picked */
+var operatorsFile string = "operators.json"
+
+/* Where operators publish their claim token, on the pool website or API */
+const claimPath string = "/.well-known/turtlecoin-pool-bot.txt"
+
+/* How long an operator has to publish their token */
+const claimTimeout time.Duration = time.Hour * 24
+
+/* The most of a claim file we read */
+const claimFileLimit = 64 * 1024
+
+/* Claims are fetched with TLS verified, unlike the pool APIs, so no one in
+   the middle can fake one */
+var claimClient = &http.Client {
+    Timeout: time.Duration(8 * time.Second),
+    Transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+}
+
+/* The smallest and largest threshold an operator can pick, so they can't
+   effectively turn off the fork alerts */
+const minMaxDifference = 2
+const maxMaxDifference = 50
+
+type OperatorInfo struct {
+    Operators       []string `json:"operators"`
+    MaxDifference   int      `json:"maxDifference,omitempty"`
+}
+
+/* A token we've given out, but not seen published yet */
+type PendingClaim struct {
+    token       string
+    expires     time.Time
+}
+
+/* Keyed by pool and user ID. Commands can run at the same time, so they
+   need a lock */
+type PendingClaims struct {
+    sync.Mutex
+    claims      map[string]PendingClaim
+}
+
+var pendingClaims = PendingClaims{claims: make(map[string]PendingClaim)}
+
+func (p *PendingClaims) add(key string, pending PendingClaim) {
+    p.Lock()
+    defer p.Unlock()
+
+    p.claims[key] = pending
+}
+
+/* Returns the claim, if it hasn't expired */
+func (p *PendingClaims) get(key string) (PendingClaim, bool) {
+    p.Lock()
+    defer p.Unlock()
+
+    pending, ok := p.claims[key]
+
+    if ok && now().After(pending.expires) {
+        delete(p.claims, key)
+        return pending, false
+    }
+
+    return pending, ok
+}
+
+func (p *PendingClaims) remove(key string) {
+    p.Lock()
+    defer p.Unlock()
+
+    delete(p.claims, key)
+}
+
+func getOperators() (map[string]OperatorInfo, error) {
+    operators := make(map[string]OperatorInfo)
+
+    /* File exists */
+    if _, err := os.Stat(operatorsFile); err == nil {
+        body, err := ioutil.ReadFile(operatorsFile)
+
+        if err != nil {
+            logError("Failed to read operators",
+                     Fields{"file": operatorsFile, "error": err})
+            return operators, err
+        }
+
+        if err := json.Unmarshal(body, &operators); err != nil {
+            logError("Failed to parse operators",
+                     Fields{"file": operatorsFile, "error": err})
+            return operators, err
+        }
+    }
+
+    return operators, nil
+}
+
+func writeOperators() {
+    operators := make(map[string]OperatorInfo)
+
+    /* Kept in case the pool comes back */
+    for url, info := range globalInfo.dormantOperators {
+        operators[url] = info
+    }
+
+    for _, v := range globalInfo.pools {
+        if len(v.operators) != 0 || v.maxDifference != 0 {
+            operators[v.url] = OperatorInfo{Operators: v.operators,
+                                            MaxDifference: v.maxDifference}
+        }
+    }
+
+    body, err := json.MarshalIndent(operators, "", "    ")
+
+    if err != nil {
+        logError("Failed to encode operators", Fields{"error": err})
+        return
+    }
+
+    if err := ioutil.WriteFile(operatorsFile, body, 0644); err != nil {
+        logError("Failed to write operators",
+                 Fields{"file": operatorsFile, "error": err})
+    }
+}
+
+/* Pool operators and admins can manage any pool, verified operators only
+   their own */
+func canManagePool(c *CommandContext, v *PoolInfo) bool {
+    return c.level >= levelOperator || elem(c.userID, v.operators)
+}
+
+func isAnyPoolOperator(userID string) bool {
+    for _, v := range globalInfo.pools {
+        if elem(userID, v.operators) {
+            return true
+        }
+    }
+
+    return false
+}
+
+/* The places the token can be published - the pool website, and the host
+   the API is on, which is often different */
+func claimURLs(v *PoolInfo) []string {
+    urls := []string{"https://" + v.url + claimPath}
+
+    if api, err := url.Parse(v.api); err == nil && api.Host != "" &&
+       api.Host != v.url {
+        /* Even if the API is plain http, the claim has to be https */
+        urls = append(urls, "https://" + api.Host + claimPath)
+    }
+
+    return urls
+}
+
+func makeClaimToken() (string, error) {
+    token := make([]byte, 16)
+
+    if _, err := rand.Read(token); err != nil {
+        return "", err
+    }
+
+    return "turtlecoin-pool-bot-" + hex.EncodeToString(token), nil
+}
+
+/* /claim <pool> [verify] */
+func claimCommand(c *CommandContext, args []string) {
+    if len(args) == 2 && strings.ToLower(args[1]) != "verify" {
+        c.replyPrivate(fmt.Sprintf("Usage: `%sclaim <pool> [verify]`",
+                                   commandPrefix()))
+        return
+    }
+
+    v := resolvePool(c, args[0])
+
+    if v == nil {
+        return
+    }
+
+    if elem(c.userID, v.operators) {
+        c.replyPrivate(fmt.Sprintf("You are already a verified operator of " +
+                                   "%s!", v.url))
+        return
+    }
+
+    key := v.url + ":" + c.userID
+
+    if len(args) == 2 {
+        verifyClaim(c, v, key)
+        return
+    }
+
+    token, err := makeClaimToken()
+
+    if err != nil {
+        logError("Failed to generate claim token", Fields{"error": err})
+        return
+    }
+
+    pendingClaims.add(key, PendingClaim{token: token,
+                                        expires: now().Add(claimTimeout)})
+
+    c.replyPrivate(fmt.Sprintf("To prove you run %s, publish this token:" +
+                               "\n\n`%s`\n\nat one of:\n\n%s\n\nThen type " +
+                               "`%sclaim %s verify`. The token expires in " +
+                               "%d hours.", v.url, token,
+                               strings.Join(claimURLs(v), "\n"),
+                               commandPrefix(), v.url,
+                               int(claimTimeout.Hours())))
+}
+
+func verifyClaim(c *CommandContext, v *PoolInfo, key string) {
+    pending, ok := pendingClaims.get(key)
+
+    if !ok {
+        c.replyPrivate(fmt.Sprintf("You don't have a claim token for %s, " +
+                                   "or it has expired - type `%sclaim %s` " +
+                                   "to get one.", v.url, commandPrefix(),
+                                   v.url))
+        return
+    }
+
+    /* Fetching the claim files can take longer than discord waits */
+    c.deferReply(true)
+
+    for _, claimURL := range claimURLs(v) {
+        body, err := fetchClaimFile(claimURL)
+
+        if err != nil {
+            logInfo("Failed to fetch claim file",
+                    Fields{"pool": v.url, "url": claimURL, "error": err})
+            continue
+        }
+
+        for _, line := range strings.Split(body, "\n") {
+            if strings.TrimSpace(line) != pending.token {
+                continue
+            }
+
+            pendingClaims.remove(key)
+
+            v.operators = append(v.operators, c.userID)
+
+            writeOperators()
+
+            c.replyPrivate(fmt.Sprintf("Verified! You are now an operator " +
+                                       "of %s. You can remove the token " +
+                                       "now.", v.url))
+            return
+        }
+    }
+
+    c.replyPrivate(fmt.Sprintf("Couldn't find your token at:\n\n%s\n\nIt " +
+                               "should be on a line on its own.",
+                               strings.Join(claimURLs(v), "\n")))
+}
+
+func fetchClaimFile(claimURL string) (string, error) {
+    resp, err := claimClient.Get(claimURL)
+
+    if err != nil {
+        return "", err
+    }
+
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("Unexpected status %s", resp.Status)
+    }
+
+    body, err := ioutil.ReadAll(io.LimitReader(resp.Body, claimFileLimit))
+
+    if err != nil {
+        return "", err
+    }
+
+    return string(body), nil
+}
+
+/* /unclaim <pool> */
+func unclaimCommand(c *CommandContext, args []string) {
+    v := resolvePool(c, args[0])
+
+    if v == nil {
+        return
+    }
+
+    if !elem(c.userID, v.operators) {
+        c.replyPrivate(fmt.Sprintf("You are not a verified operator of %s!",
+                                   v.url))
+        return
+    }
+
+    v.operators = deleteElem(c.userID, v.operators)
+
+    writeOperators()
+
+    c.replyPrivate(fmt.Sprintf("You are no longer an operator of %s.",
+                               v.url))
+}
+
+/* /threshold <pool> <blocks|default> */
+func thresholdCommand(c *CommandContext, args []string) {
+    v := resolvePool(c, args[0])
+
+    if v == nil {
+        return
+    }
+
+    if !canManagePool(c, v) {
+        c.replyPrivate(fmt.Sprintf("Only pool operators and verified " +
+                                   "operators of %s can change its " +
+                                   "threshold!", v.url))
+        return
+    }
+
+    if len(args) == 1 {
+        c.replyPrivate(fmt.Sprintf("%s is alerted about when it is more " +
+                                   "than %d blocks from the median.", v.url,
+                                   poolThreshold(v)))
+        return
+    }
+
+    if strings.ToLower(args[1]) == "default" {
+        v.maxDifference = 0
+    } else {
+        blocks, err := strconv.Atoi(args[1])
+
+        if err != nil || blocks < minMaxDifference ||
+           blocks > maxMaxDifference {
+            c.replyPrivate(fmt.Sprintf("The threshold must be between %d " +
+                                       "and %d blocks, or `default`.",
+                                       minMaxDifference, maxMaxDifference))
+            return
+        }
+
+        v.maxDifference = blocks
+    }
+
+    writeOperators()
+
+    c.reply(fmt.Sprintf("%s will be alerted about when it is more than %d " +
+                        "blocks from the median.", v.url, poolThreshold(v)))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+    "bytes"
+    "log"
+    "regexp"
+    "strings"
+    "testing"
+    "time"
+    "net/http"
+    "net/http/httptest"
+    "io/ioutil"
+)
+
+/* A pool website serving a claim file */
+func startClaimServer(t *testing.T, status int,
+                      body *string) *httptest.Server {
+    server := httptest.NewUnstartedServer(http.HandlerFunc(
+        func(w http.ResponseWriter, r *http.Request) {
+            if r.URL.Path != claimPath {
+                http.NotFound(w, r)
+                return
+            }
+
+            w.WriteHeader(status)
+            w.Write([]byte(*body))
+        }))
+
+    /* Clients that don't trust it are expected */
+    server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+    server.StartTLS()
+
+    t.Cleanup(server.Close)
+
+    return server
+}
+
+var tokenRegex = regexp.MustCompile("turtlecoin-pool-bot-[0-9a-f]+")
+
+/* Runs /claim, and returns the token we're given */
+func startClaim(t *testing.T, chat *FakeChat, pool string) string {
+    replies := say(chat, testUser, botsChannel, "/claim " + pool)
+
+    token := tokenRegex.FindString(allText(replies))
+
+    if token == "" {
+        t.Fatalf("No token in:\n%s", allText(replies))
+    }
+
+    return token
+}
+
+func setupClaimTest(t *testing.T, status int,
+                    body *string) (*FakeChat, *httptest.Server) {
+    chat := setupTest(t)
+
+    server := startClaimServer(t, status, body)
+    host := strings.TrimPrefix(server.URL, "https://")
+
+    pool := testPool(host, 1000)
+    pool.api = server.URL + "/api/"
+
+    globalInfo.pools = []PoolInfo{pool}
+
+    return chat, server
+}
+
+func TestClaim(t *testing.T) {
+    body := ""
+
+    chat, server := setupClaimTest(t, http.StatusOK, &body)
+
+    /* Trust the test server's certificate */
+    old := claimClient
+    claimClient = server.Client()
+    defer func() { claimClient = old }()
+
+    /* The claims shouldn't end up in the pool traffic */
+    var recorded bytes.Buffer
+    archive.out = &recorded
+
+    pool := globalInfo.pools[0].url
+
+    token := startClaim(t, chat, pool)
+
+    replies := say(chat, testUser, botsChannel,
+                   "/claim " + pool + " verify")
+    expectText(t, replies, "Couldn't find your token")
+
+    body = "something else\n" + token + "\n"
+
+    replies = say(chat, testUser, botsChannel,
+                  "/claim " + pool + " verify")
+    expectText(t, replies, "Verified! You are now an operator of " + pool)
+
+    if !elem(testUser, globalInfo.pools[0].operators) {
+        t.Errorf("Not made an operator")
+    }
+
+    operators, err := getOperators()
+
+    if err != nil || !elem(testUser, operators[pool].Operators) {
+        t.Errorf("Operator not saved: %v %v", operators, err)
+    }
+
+    if recorded.Len() != 0 {
+        t.Errorf("Claim fetches were archived:\n%s", recorded.String())
+    }
+
+    replies = say(chat, testUser, botsChannel, "/pool " + pool)
+    expectText(t, replies, "Verified operators: <@" + testUser + ">")
+}
+
+func TestClaimNeedsOK(t *testing.T) {
+    body := ""
+
+    chat, server := setupClaimTest(t, http.StatusNotFound, &body)
+
+    old := claimClient
+    claimClient = server.Client()
+    defer func() { claimClient = old }()
+
+    pool := globalInfo.pools[0].url
+
+    /* An error page that happens to echo the token back */
+    body = startClaim(t, chat, pool)
+
+    replies := say(chat, testUser, botsChannel,
+                   "/claim " + pool + " verify")
+    expectText(t, replies, "Couldn't find your token")
+
+    if len(globalInfo.pools[0].operators) != 0 {
+        t.Errorf("Verified from a %d", http.StatusNotFound)
+    }
+}
+
+func TestClaimVerifiesTLS(t *testing.T) {
+    body := ""
+
+    chat, _ := setupClaimTest(t, http.StatusOK, &body)
+
+    /* Nothing trusts the test server's certificate, as if someone were
+       in the middle */
+    pool := globalInfo.pools[0].url
+
+    body = startClaim(t, chat, pool)
+
+    replies := say(chat, testUser, botsChannel,
+                   "/claim " + pool + " verify")
+    expectText(t, replies, "Couldn't find your token")
+
+    if len(globalInfo.pools[0].operators) != 0 {
+        t.Errorf("Verified over an untrusted certificate")
+    }
+}
+
+func TestClaimExpires(t *testing.T) {
+    body := ""
+
+    chat, server := setupClaimTest(t, http.StatusOK, &body)
+
+    old := claimClient
+    claimClient = server.Client()
+    defer func() { claimClient = old }()
+
+    pool := globalInfo.pools[0].url
+
+    body = startClaim(t, chat, pool)
+
+    clock.(*FakeClock).advance(claimTimeout + time.Minute)
+
+    replies := say(chat, testUser, botsChannel,
+                   "/claim " + pool + " verify")
+    expectText(t, replies, "You don't have a claim token for " + pool +
+                           ", or it has expired")
+
+    if len(globalInfo.pools[0].operators) != 0 {
+        t.Errorf("Verified with an expired token")
+    }
+}
+
+func TestClaimURLs(t *testing.T) {
+    v := testPool("pool.example", 1000)
+    v.api = "http://api.pool.example:8117/"
+
+    urls := claimURLs(&v)
+
+    expected := []string {
+        "https://pool.example" + claimPath,
+        "https://api.pool.example:8117" + claimPath,
+    }
+
+    if strings.Join(urls, " ") != strings.Join(expected, " ") {
+        t.Errorf("Got %v, expected %v", urls, expected)
+    }
+}
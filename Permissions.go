@@ -0,0 +1,126 @@
+package main
+
+import (
+    "fmt"
+)
+
+/* Who is allowed to run a command. Each level can do everything the levels
+   below it can */
+type PermissionLevel int
+
+const (
+    /* Anyone, in the pools or bots channel */
+    levelPublic PermissionLevel = iota
+    /* Can also use the bot in any channel */
+    levelTrusted
+    /* Looks after the pools - can silence any pool and change its
+       threshold */
+    levelOperator
+    /* Can change how the bot behaves */
+    levelAdmin
+)
+
+func (l PermissionLevel) String() string {
+    switch l {
+    case levelTrusted:
+        return "trusted"
+    case levelOperator:
+        return "pool operator"
+    case levelAdmin:
+        return "admin"
+    default:
+        return "public"
+    }
+}
+
+/* The discord role IDs that grant each level */
+type PermissionsConfig struct {
+    Trusted     []string `json:"trusted"`
+    Operator    []string `json:"operator"`
+    Admin       []string `json:"admin"`
+}
+
+/* Before roles could be configured, these role names got you the trusted
+   level. Only used if no trusted roles are configured */
+var legacyTrustedRoles = []string {
+    "NINJA", "Developer", "helper", "FOOTCLAN", "Contributor", "PR Guerilla",
+    "Service Operator", "Enforcer", "core",
+}
+
+/* Works out the highest level any of the users roles give them */
+func permissionLevel(s ChatClient, guildID string,
+                     roles []string) PermissionLevel {
+    level := levelPublic
+
+    for _, role := range roles {
+        if elem(role, config.Permissions.Admin) {
+            return levelAdmin
+        }
+
+        if elem(role, config.Permissions.Operator) && level < levelOperator {
+            level = levelOperator
+        }
+
+        if elem(role, config.Permissions.Trusted) && level < levelTrusted {
+            level = levelTrusted
+        }
+    }
+
+    if level == levelPublic && len(config.Permissions.Trusted) == 0 &&
+       s != nil {
+        for _, v := range roles {
+            role, err := s.Role(guildID, v)
+
+            if err != nil {
+                logWarn("Failed to get role",
+                        Fields{"role": v, "error": err})
+                continue
+            }
+
+            if elem(role.Name, legacyTrustedRoles) {
+                return levelTrusted
+            }
+        }
+    }
+
+    return level
+}
+
+func hasPermission(c *CommandContext, cmd *Command) bool {
+    if c.level >= cmd.level {
+        return true
+    }
+
+    /* The command checks which pool they run itself */
+    return cmd.verifiedOperators && isAnyPoolOperator(c.userID)
+}
+
+/* Checks the user can run the command here, telling them why not if they
+   can't */
+func checkPermission(c *CommandContext, cmd *Command) bool {
+    if !hasPermission(c, cmd) {
+        c.replyPrivate(fmt.Sprintf("You need to be %s %s to use `%s%s`!",
+                                   article(cmd.level.String()),
+                                   cmd.level, commandPrefix(), cmd.name))
+        return false
+    }
+
+    /* Public users can only use the bot in the pools or bots channel */
+    if c.level < levelTrusted && c.channelID != poolsChannel &&
+       c.channelID != botsChannel {
+        c.replyPrivate(fmt.Sprintf("You can only use `%s%s` in the #stats " +
+                                   "or #bots channels!", commandPrefix(),
+                                   cmd.name))
+        return false
+    }
+
+    return true
+}
+
+func article(word string) string {
+    if len(word) != 0 && elem(word[:1], []string{"a", "e", "i", "o", "u"}) {
+        return "an"
+    }
+
+    return "a"
+}
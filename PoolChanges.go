@@ -0,0 +1,229 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "github.com/bwmarrin/discordgo"
+)
+
+/* A pool whose api or type changed in the pools list */
+type PoolChange struct {
+    url         string
+    oldApi      string
+    newApi      string
+    oldType     string
+    newType     string
+}
+
+/* The difference between the old pools list and the new one */
+type PoolChanges struct {
+    added       []PoolInfo
+    removed     []PoolInfo
+    changed     []PoolChange
+}
+
+func (c PoolChanges) empty() bool {
+    return len(c.added) == 0 && len(c.removed) == 0 && len(c.changed) == 0
+}
+
+func findPoolInfo(pools []PoolInfo, url string) *PoolInfo {
+    for index, _ := range pools {
+        if pools[index].url == url {
+            return &pools[index]
+        }
+    }
+
+    return nil
+}
+
+func diffPools(oldPools []PoolInfo, newPools []PoolInfo) PoolChanges {
+    var changes PoolChanges
+
+    /* The first download, everything is new */
+    if len(oldPools) == 0 {
+        return changes
+    }
+
+    for _, p := range newPools {
+        old := findPoolInfo(oldPools, p.url)
+
+        if old == nil {
+            changes.added = append(changes.added, p)
+            continue
+        }
+
+        if old.api != p.api || old.poolType != p.poolType {
+            changes.changed = append(changes.changed,
+                PoolChange{url: p.url, oldApi: old.api, newApi: p.api,
+                           oldType: old.poolType, newType: p.poolType})
+        }
+    }
+
+    for _, p := range oldPools {
+        if findPoolInfo(newPools, p.url) == nil {
+            changes.removed = append(changes.removed, p)
+        }
+    }
+
+    return changes
+}
+
+/* The claims of pools that aren't in the list any more. We keep them in
+   case the pool comes back */
+func dormantClaims(claims map[string]Watchers,
+                   pools []PoolInfo) map[string]Watchers {
+    dormant := make(map[string]Watchers)
+
+    for url, watchers := range claims {
+        if findPoolInfo(pools, url) == nil {
+            dormant[url] = watchers
+        }
+    }
+
+    return dormant
+}
+
+/* Likewise for the email addresses */
+func dormantEmails(emails map[string]EmailWatchers,
+                   pools []PoolInfo) map[string]EmailWatchers {
+    dormant := make(map[string]EmailWatchers)
+
+    for url, watchers := range emails {
+        if findPoolInfo(pools, url) == nil {
+            dormant[url] = watchers
+        }
+    }
+
+    return dormant
+}
+
+/* And the verified operators and thresholds */
+func dormantOperators(operators map[string]OperatorInfo,
+                      pools []PoolInfo) map[string]OperatorInfo {
+    dormant := make(map[string]OperatorInfo)
+
+    for url, info := range operators {
+        if findPoolInfo(pools, url) == nil {
+            dormant[url] = info
+        }
+    }
+
+    return dormant
+}
+
+/* The dormant claims, in a fixed order so claims.txt doesn't churn */
+func dormantClaimURLs() []string {
+    urls := make([]string, 0)
+
+    for url, _ := range globalInfo.dormantClaims {
+        urls = append(urls, url)
+    }
+
+    sort.Strings(urls)
+
+    return urls
+}
+
+/* The same for emails.txt */
+func dormantEmailURLs() []string {
+    urls := make([]string, 0)
+
+    for url, _ := range globalInfo.dormantEmails {
+        urls = append(urls, url)
+    }
+
+    sort.Strings(urls)
+
+    return urls
+}
+
+func describePoolChanges(changes PoolChanges) []string {
+    lines := make([]string, 0)
+
+    for _, p := range changes.added {
+        lines = append(lines, fmt.Sprintf("➕ Added %s (%s, %s)", p.url,
+                                          p.poolType, p.api))
+    }
+
+    for _, p := range changes.removed {
+        lines = append(lines, fmt.Sprintf("➖ Removed %s", p.url))
+    }
+
+    for _, c := range changes.changed {
+        what := make([]string, 0)
+
+        if c.oldApi != c.newApi {
+            what = append(what, fmt.Sprintf("API changed from %s to %s",
+                                             c.oldApi, c.newApi))
+        }
+
+        if c.oldType != c.newType {
+            what = append(what, fmt.Sprintf("type changed from %s to %s",
+                                             c.oldType, c.newType))
+        }
+
+        lines = append(lines, fmt.Sprintf("✏️ %s: %s", c.url,
+                                          strings.Join(what, ", ")))
+    }
+
+    return lines
+}
+
+/* Tells the bots channel what changed, and the people watching any removed
+   pools */
+func announcePoolChanges(s ChatClient, changes PoolChanges) {
+    if changes.empty() {
+        return
+    }
+
+    msg := "The pools list has changed:\n"
+
+    for _, line := range describePoolChanges(changes) {
+        /* Message length will exceed discord limit, send what we have so
+           far then continue */
+        if len(msg) + len(line) >= messageLimit - 200 {
+            sendPoolChanges(s, msg)
+            msg = ""
+        }
+
+        msg += line + "\n"
+    }
+
+    sendPoolChanges(s, msg)
+
+    for _, p := range changes.removed {
+        for _, owner := range p.claimees {
+            sendDirectMessage(s, owner,
+                fmt.Sprintf("%s has been removed from the pools list, so " +
+                            "you won't get alerts about it any more. Your " +
+                            "watch is kept, and will start again if it " +
+                            "comes back.", p.url))
+        }
+    }
+}
+
+func sendPoolChanges(s ChatClient, msg string) {
+    _, err := s.ChannelMessageSendComplex(botsChannel,
+                                          &discordgo.MessageSend{Content: msg})
+
+    if err != nil {
+        metrics.discordSendFailed()
+        logError("Failed to post pools list changes", Fields{"error": err})
+    }
+}
+
+func sendDirectMessage(s ChatClient, userID string, msg string) {
+    channel, err := s.UserChannelCreate(userID)
+
+    if err == nil {
+        _, err = s.ChannelMessageSendComplex(channel.ID,
+                     &discordgo.MessageSend{Content: msg})
+    }
+
+    if err != nil {
+        metrics.discordSendFailed()
+        logWarn("Failed to send direct message",
+                Fields{"user": userID, "error": err})
+    }
+}
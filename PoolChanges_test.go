@@ -0,0 +1,77 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "io/ioutil"
+)
+
+func TestRemovedPoolsKeepTheirWatchers(t *testing.T) {
+    chat := setupTest(t)
+
+    state := map[string]string {
+        "claims.txt": "b.example:" + testUser + "\n",
+        "emails.txt": "b.example:someone@example.com\n",
+        "operators.json": "{\"b.example\":{\"operators\":[\"" + testUser +
+                          "\"],\"maxDifference\":10}}",
+    }
+
+    for file, body := range state {
+        if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+            t.Fatalf("Failed to write %s: %s", file, err)
+        }
+    }
+
+    stubPoolApis(map[string]int{"a.example": 1000, "b.example": 1000})
+
+    writePoolsList(t, "a.example", "b.example")
+
+    if _, err := updatePools(false); err != nil {
+        t.Fatalf("Failed to update pools: %s", err)
+    }
+
+    /* b.example leaves the list, and everything is saved while it's gone */
+    writePoolsList(t, "a.example")
+
+    if _, err := updatePools(false); err != nil {
+        t.Fatalf("Failed to update pools: %s", err)
+    }
+
+    globalInfo.pools[0].operators = []string{testOtherUser}
+    globalInfo.pools[0].emailees = []string{"other@example.com"}
+
+    say(chat, testOtherUser, poolsChannel, "/watch a.example")
+    say(chat, testOtherUser, botsChannel, "/threshold a.example 20")
+    writeEmails()
+
+    for file, _ := range state {
+        body, err := ioutil.ReadFile(file)
+
+        if err != nil || !strings.Contains(string(body), "b.example") {
+            t.Errorf("b.example dropped from %s: %q %v", file, body, err)
+        }
+
+        if err != nil || !strings.Contains(string(body), "a.example") {
+            t.Errorf("a.example not saved in %s: %q %v", file, body, err)
+        }
+    }
+
+    /* And it all comes back with it */
+    writePoolsList(t, "a.example", "b.example")
+
+    if _, err := updatePools(false); err != nil {
+        t.Fatalf("Failed to update pools: %s", err)
+    }
+
+    v := findPool("b.example")
+
+    if v == nil {
+        t.Fatalf("b.example not back")
+    }
+
+    if !elem(testUser, v.claimees) ||
+       !elem("someone@example.com", v.emailees) ||
+       !elem(testUser, v.operators) || v.maxDifference != 10 {
+        t.Errorf("b.example came back without its watchers: %+v", v)
+    }
+}
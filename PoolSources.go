@@ -0,0 +1,292 @@
+package main
+
+import (
+    "os"
+    "strings"
+    "encoding/json"
+    "io/ioutil"
+)
+
+/* Local changes to the pools list, used if it exists */
+const defaultPoolOverridesFile string = "pool-overrides.json"
+
+/* What the overrides file calls itself in /pool */
+const overridesSource string = "local overrides"
+
+/* A change to one pool. If the pool isn't in the list it is added, in which
+   case it needs the api and type. Otherwise only what is set is changed */
+type PoolOverride struct {
+    Url         string `json:"url"`
+    Api         string `json:"api,omitempty"`
+    Type        string `json:"type,omitempty"`
+    /* Drop the pool from the list */
+    Exclude     bool   `json:"exclude,omitempty"`
+}
+
+type PoolOverrides struct {
+    Pools       []PoolOverride `json:"pools"`
+}
+
+/* Where we get the pools list from, in the order they are merged */
+func poolSources() []string {
+    if len(config.PoolSources) == 0 {
+        return []string{poolsJSON}
+    }
+
+    return config.PoolSources
+}
+
+func poolOverridesFile() string {
+    if config.PoolOverrides == "" {
+        return defaultPoolOverridesFile
+    }
+
+    return config.PoolOverrides
+}
+
+/* How we refer to pools - without the scheme or trailing slash */
+func trimPoolURL(url string) string {
+    url = strings.TrimPrefix(url, "https://")
+    url = strings.TrimPrefix(url, "http://")
+    return strings.TrimSuffix(url, "/")
+}
+
+func isRemoteSource(source string) bool {
+    return strings.HasPrefix(source, "http://") ||
+           strings.HasPrefix(source, "https://")
+}
+
+/* Reads one pools list, from a url or a local file. If useCache is set and
+   a url can't be downloaded, the last good copy of it is used instead, and
+   fresh is false */
+func getPoolsFrom(source string, useCache bool) (Pools, bool, error) {
+    var pools Pools
+    var entry PoolsCacheEntry
+    var err error
+
+    fresh := true
+
+    if isRemoteSource(source) {
+        entry, err = downloadPoolsList(source)
+
+        if err != nil && useCache {
+            if cached, ok := poolsCache.get(source); ok {
+                logWarn("Failed to download pools json, using the cached " +
+                        "copy", Fields{"url": source, "error": err})
+                entry, err, fresh = cached, nil, false
+            }
+        }
+    } else {
+        entry.Body, err = ioutil.ReadFile(source)
+    }
+
+    if err != nil {
+        logError("Failed to download pools json",
+                 Fields{"url": source, "error": err})
+        return pools, false, err
+    }
+
+    if err := json.Unmarshal(entry.Body, &pools); err != nil {
+        logError("Failed to parse pools json",
+                 Fields{"url": source, "error": err})
+        return pools, false, err
+    }
+
+    if isRemoteSource(source) && fresh && !entry.notModified {
+        poolsCache.put(source, entry)
+    }
+
+    for i, _ := range pools.Pools {
+        pools.Pools[i].source = source
+    }
+
+    var problems []PoolProblem
+
+    pools.Pools, problems = dedupePools(source, pools.Pools)
+    pools.problems = problems
+
+    return pools, fresh, nil
+}
+
+/* Adds the pools to the list. A pool already in the list is replaced, so
+   later sources win */
+func mergePools(list []Pool, pools []Pool) []Pool {
+    for _, pool := range pools {
+        index := findListedPool(list, pool.Url)
+
+        if index == -1 {
+            list = append(list, pool)
+        } else {
+            list[index] = pool
+        }
+    }
+
+    return list
+}
+
+func findListedPool(list []Pool, url string) int {
+    for i, pool := range list {
+        if trimPoolURL(pool.Url) == trimPoolURL(url) {
+            return i
+        }
+    }
+
+    return -1
+}
+
+func getPoolOverrides() (PoolOverrides, error) {
+    var overrides PoolOverrides
+
+    file := poolOverridesFile()
+
+    /* No overrides */
+    if _, err := os.Stat(file); err != nil {
+        return overrides, nil
+    }
+
+    body, err := ioutil.ReadFile(file)
+
+    if err != nil {
+        logError("Failed to read pool overrides",
+                 Fields{"file": file, "error": err})
+        return overrides, err
+    }
+
+    if err := json.Unmarshal(body, &overrides); err != nil {
+        logError("Failed to parse pool overrides",
+                 Fields{"file": file, "error": err})
+        return overrides, err
+    }
+
+    return overrides, nil
+}
+
+func writePoolOverrides(overrides PoolOverrides) error {
+    file := poolOverridesFile()
+
+    body, err := json.MarshalIndent(overrides, "", "    ")
+
+    if err != nil {
+        logError("Failed to encode pool overrides", Fields{"error": err})
+        return err
+    }
+
+    if err := ioutil.WriteFile(file, body, 0644); err != nil {
+        logError("Failed to write pool overrides",
+                 Fields{"file": file, "error": err})
+        return err
+    }
+
+    return nil
+}
+
+/* Changes the override for the pool, starting a new one if it has none.
+   If edit returns false, the override is dropped instead */
+func editPoolOverride(url string, edit func(o *PoolOverride) bool) error {
+    overrides, err := getPoolOverrides()
+
+    if err != nil {
+        return err
+    }
+
+    o := PoolOverride{Url: url}
+    index := -1
+
+    for i, existing := range overrides.Pools {
+        if trimPoolURL(existing.Url) == trimPoolURL(url) {
+            o = existing
+            index = i
+            break
+        }
+    }
+
+    keep := edit(&o)
+
+    switch {
+    case keep && index == -1:
+        overrides.Pools = append(overrides.Pools, o)
+    case keep:
+        overrides.Pools[index] = o
+    case index != -1:
+        overrides.Pools = append(overrides.Pools[:index],
+                                 overrides.Pools[index + 1:]...)
+    }
+
+    return writePoolOverrides(overrides)
+}
+
+func applyOverrides(list []Pool, overrides PoolOverrides) []Pool {
+    for _, o := range overrides.Pools {
+        index := findListedPool(list, o.Url)
+
+        if o.Exclude {
+            if index != -1 {
+                list = append(list[:index], list[index + 1:]...)
+            }
+
+            continue
+        }
+
+        /* Left out by validatePools if it's missing the api or type */
+        if index == -1 {
+            list = append(list, Pool{Url: o.Url, Api: o.Api, Type: o.Type,
+                                     source: overridesSource})
+            continue
+        }
+
+        pool := &list[index]
+
+        if o.Api != "" {
+            pool.Api = o.Api
+        }
+
+        if o.Type != "" {
+            pool.Type = o.Type
+        }
+
+        if o.Api != "" || o.Type != "" {
+            pool.source += ", changed by " + overridesSource
+        }
+    }
+
+    return list
+}
+
+/* Every source merged in order, with the overrides applied. If any of them
+   fail we give up, rather than losing the pools they list. Fresh is false if
+   any of them came from the cache */
+func getPools(useCache bool) (Pools, bool, error) {
+    var merged Pools
+
+    allFresh := true
+    problems := make([]PoolProblem, 0)
+
+    for _, source := range poolSources() {
+        pools, fresh, err := getPoolsFrom(source, useCache)
+
+        if err != nil {
+            return merged, false, err
+        }
+
+        allFresh = allFresh && fresh
+        problems = append(problems, pools.problems...)
+
+        merged.Pools = mergePools(merged.Pools, pools.Pools)
+    }
+
+    overrides, err := getPoolOverrides()
+
+    if err != nil {
+        return merged, false, err
+    }
+
+    merged.Pools = applyOverrides(merged.Pools, overrides)
+
+    var invalid []PoolProblem
+
+    merged.Pools, invalid = validatePools(merged.Pools)
+
+    reportPoolProblems(append(problems, invalid...), len(merged.Pools))
+
+    return merged, allFresh, nil
+}
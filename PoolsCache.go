@@ -0,0 +1,151 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "sync"
+    "net/http"
+    "encoding/json"
+    "io/ioutil"
+)
+
+/* The last good copy of each remote pools list, so we can start when they
+   can't be downloaded, and only download them again when they change */
+var poolsCacheFile string = "pools-cache.json"
+
+type PoolsCacheEntry struct {
+    ETag            string          `json:"etag,omitempty"`
+    LastModified    string          `json:"lastModified,omitempty"`
+    Body            json.RawMessage `json:"body"`
+    /* Set when the server told us our copy is still current, so there's
+       nothing new to save */
+    notModified     bool
+}
+
+type PoolsCache struct {
+    sync.Mutex
+    /* Keyed by url. Nil until read from disk */
+    entries     map[string]PoolsCacheEntry
+}
+
+var poolsCache PoolsCache
+
+/* Must be called with the cache locked */
+func (c *PoolsCache) load() {
+    if c.entries != nil {
+        return
+    }
+
+    c.entries = make(map[string]PoolsCacheEntry)
+
+    /* Nothing cached yet */
+    if _, err := os.Stat(poolsCacheFile); err != nil {
+        return
+    }
+
+    body, err := ioutil.ReadFile(poolsCacheFile)
+
+    if err == nil {
+        err = json.Unmarshal(body, &c.entries)
+    }
+
+    /* Not worth failing over, we'll download them again */
+    if err != nil {
+        logWarn("Failed to read pools cache",
+                Fields{"file": poolsCacheFile, "error": err})
+        c.entries = make(map[string]PoolsCacheEntry)
+    }
+}
+
+func (c *PoolsCache) get(url string) (PoolsCacheEntry, bool) {
+    c.Lock()
+    defer c.Unlock()
+
+    c.load()
+
+    entry, ok := c.entries[url]
+
+    return entry, ok
+}
+
+/* Only called once the body has parsed */
+func (c *PoolsCache) put(url string, entry PoolsCacheEntry) {
+    c.Lock()
+    defer c.Unlock()
+
+    c.load()
+
+    c.entries[url] = entry
+
+    body, err := json.MarshalIndent(c.entries, "", "    ")
+
+    if err != nil {
+        logError("Failed to encode pools cache", Fields{"error": err})
+        return
+    }
+
+    if err := ioutil.WriteFile(poolsCacheFile, body, 0644); err != nil {
+        logError("Failed to write pools cache",
+                 Fields{"file": poolsCacheFile, "error": err})
+    }
+}
+
+/* The pools lists are fetched with TLS verified, unlike the pool APIs */
+var poolsListTransport = &http.Transport{Proxy: http.ProxyFromEnvironment}
+
+/* Downloads the list, unless it hasn't changed since we cached it */
+func downloadPoolsList(url string) (PoolsCacheEntry, error) {
+    var entry PoolsCacheEntry
+
+    req, err := http.NewRequest("GET", url, nil)
+
+    if err != nil {
+        return entry, err
+    }
+
+    cached, haveCached := poolsCache.get(url)
+
+    if haveCached {
+        if cached.ETag != "" {
+            req.Header.Set("If-None-Match", cached.ETag)
+        }
+
+        if cached.LastModified != "" {
+            req.Header.Set("If-Modified-Since", cached.LastModified)
+        }
+    }
+
+    client := http.Client {
+        Timeout: poolsJSONTimeout,
+        Transport: poolsListTransport,
+    }
+
+    resp, err := client.Do(req)
+
+    if err != nil {
+        return entry, err
+    }
+
+    defer resp.Body.Close()
+
+    if resp.StatusCode == http.StatusNotModified && haveCached {
+        cached.notModified = true
+        return cached, nil
+    }
+
+    if resp.StatusCode != http.StatusOK {
+        return entry, fmt.Errorf("Unexpected status %s", resp.Status)
+    }
+
+    body, err := ioutil.ReadAll(resp.Body)
+
+    if err != nil {
+        return entry, err
+    }
+
+    entry.ETag = resp.Header.Get("ETag")
+    entry.LastModified = resp.Header.Get("Last-Modified")
+    entry.Body = body
+
+    return entry, nil
+}
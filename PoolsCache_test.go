@@ -0,0 +1,99 @@
+package main
+
+import (
+    "log"
+    "os"
+    "testing"
+    "time"
+    "net/http"
+    "net/http/httptest"
+    "io/ioutil"
+)
+
+const testPoolsList string = "{\"pools\":[{\"url\":\"a.example\"," +
+                             "\"api\":\"https://a.example/api/\"," +
+                             "\"type\":\"forknote\"}]}"
+
+/* Serves the pools list with an ETag, counting the downloads */
+func startPoolsListServer(t *testing.T, tls bool) (*httptest.Server, *int) {
+    downloads := 0
+
+    handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("If-None-Match") == "\"v1\"" {
+            w.WriteHeader(http.StatusNotModified)
+            return
+        }
+
+        downloads++
+
+        w.Header().Set("ETag", "\"v1\"")
+        w.Write([]byte(testPoolsList))
+    })
+
+    server := httptest.NewUnstartedServer(handler)
+
+    if tls {
+        /* Clients that don't trust it are expected */
+        server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+        server.StartTLS()
+    } else {
+        server.Start()
+    }
+
+    t.Cleanup(server.Close)
+
+    return server, &downloads
+}
+
+func TestPoolsListNotModified(t *testing.T) {
+    setupTest(t)
+
+    server, downloads := startPoolsListServer(t, false)
+
+    if _, _, err := getPoolsFrom(server.URL, true); err != nil {
+        t.Fatalf("Failed to get pools: %s", err)
+    }
+
+    before, err := os.Stat(poolsCacheFile)
+
+    if err != nil {
+        t.Fatalf("Pools list not cached: %s", err)
+    }
+
+    /* Far enough apart that a rewrite would change the time */
+    old := before.ModTime().Add(-time.Hour)
+    os.Chtimes(poolsCacheFile, old, old)
+
+    pools, fresh, err := getPoolsFrom(server.URL, true)
+
+    if err != nil || !fresh || len(pools.Pools) != 1 {
+        t.Fatalf("Got %+v %v %v from the cached copy", pools, fresh, err)
+    }
+
+    if *downloads != 1 {
+        t.Errorf("Downloaded %d times", *downloads)
+    }
+
+    after, err := os.Stat(poolsCacheFile)
+
+    if err != nil || !after.ModTime().Equal(old) {
+        t.Errorf("Cache rewritten for an unchanged list")
+    }
+}
+
+/* Fetching a pool API mustn't turn off TLS verification for the list */
+func TestPoolsListVerifiesTLS(t *testing.T) {
+    setupTest(t)
+
+    server, downloads := startPoolsListServer(t, true)
+
+    fetchApiLive(server.URL)
+
+    if _, _, err := getPoolsFrom(server.URL, true); err == nil {
+        t.Errorf("Downloaded over an untrusted certificate")
+    }
+
+    if *downloads != 1 {
+        t.Errorf("The API was fetched %d times", *downloads)
+    }
+}
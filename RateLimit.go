@@ -0,0 +1,233 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* How many times a command can be used. Each user, and each channel, gets a
+   bucket of Burst tokens, which refills at PerMinute tokens a minute */
+type RateBudget struct {
+    UserBurst           int     `json:"userBurst"`
+    UserPerMinute       float64 `json:"userPerMinute"`
+    ChannelBurst        int     `json:"channelBurst"`
+    ChannelPerMinute    float64 `json:"channelPerMinute"`
+}
+
+type RateLimitConfig struct {
+    /* Overrides the budget of a command, by command name */
+    Commands            map[string]RateBudget `json:"commands"`
+    /* How long after a command is answered that the same command in the
+       same channel gets a link to the answer, rather than a repost, e.g.
+       "2m" */
+    DuplicateWindow     string `json:"duplicateWindow"`
+}
+
+/* Used for any command without its own budget */
+var defaultBudget = RateBudget {
+    UserBurst: 5,
+    UserPerMinute: 5,
+    ChannelBurst: 10,
+    ChannelPerMinute: 10,
+}
+
+/* /heights and /forked post a lot, so they get a smaller budget */
+var defaultCommandBudgets = map[string]RateBudget {
+    "heights": RateBudget {
+        UserBurst: 2,
+        UserPerMinute: 1,
+        ChannelBurst: 3,
+        ChannelPerMinute: 1,
+    },
+    "forked": RateBudget {
+        UserBurst: 2,
+        UserPerMinute: 1,
+        ChannelBurst: 3,
+        ChannelPerMinute: 1,
+    },
+}
+
+const defaultDuplicateWindow time.Duration = time.Minute * 2
+
+type TokenBucket struct {
+    tokens              float64
+    lastUpdated         time.Time
+    /* Whether we've told them they're out of tokens, so we only tell them
+       once */
+    warned              bool
+}
+
+/* The last public answer to a command in a channel */
+type RecentReply struct {
+    when                time.Time
+    messageID           string
+}
+
+type RateLimiter struct {
+    sync.Mutex
+    buckets             map[string]*TokenBucket
+    recent              map[string]RecentReply
+}
+
+var rateLimiter = RateLimiter {
+    buckets: make(map[string]*TokenBucket),
+    recent: make(map[string]RecentReply),
+}
+
+func commandBudget(name string) RateBudget {
+    if budget, ok := config.RateLimits.Commands[name]; ok {
+        return budget
+    }
+
+    if budget, ok := defaultCommandBudgets[name]; ok {
+        return budget
+    }
+
+    return defaultBudget
+}
+
+func duplicateWindow() time.Duration {
+    if config.RateLimits.DuplicateWindow != "" {
+        d, err := time.ParseDuration(config.RateLimits.DuplicateWindow)
+
+        if err == nil {
+            return d
+        }
+
+        logWarn("Invalid duplicateWindow in config", Fields{"error": err})
+    }
+
+    return defaultDuplicateWindow
+}
+
+/* Refills the bucket for the time passed, then takes a token if there is
+   one. Returns how long until there is a token if there isn't */
+func (b *TokenBucket) take(burst int, perMinute float64) (bool,
+                                                          time.Duration) {
+    current := now()
+
+    b.tokens += current.Sub(b.lastUpdated).Minutes() * perMinute
+    b.lastUpdated = current
+
+    if b.tokens > float64(burst) {
+        b.tokens = float64(burst)
+    }
+
+    if b.tokens >= 1 {
+        b.tokens--
+        b.warned = false
+        return true, 0
+    }
+
+    if perMinute <= 0 {
+        return false, time.Hour
+    }
+
+    wait := time.Duration((1 - b.tokens) / perMinute * float64(time.Minute))
+
+    return false, wait
+}
+
+func (r *RateLimiter) bucket(key string, burst int) *TokenBucket {
+    b, ok := r.buckets[key]
+
+    /* New buckets start full */
+    if !ok {
+        b = &TokenBucket{tokens: float64(burst), lastUpdated: now()}
+        r.buckets[key] = b
+    }
+
+    return b
+}
+
+func duplicateKey(c *CommandContext, cmd *Command, args []string) string {
+    return c.channelID + ":" + cmd.name + ":" +
+           strings.ToLower(strings.Join(args, " "))
+}
+
+/* Checks the user and channel haven't run out of tokens for this command,
+   and whether the same thing was just answered. Tells the user if so */
+func checkRateLimit(c *CommandContext, cmd *Command, args []string) bool {
+    /* Admins need to be able to use the bot during an incident */
+    if c.level >= levelAdmin {
+        return true
+    }
+
+    rateLimiter.Lock()
+    defer rateLimiter.Unlock()
+
+    if cmd.dedupe {
+        key := duplicateKey(c, cmd, args)
+
+        if recent, ok := rateLimiter.recent[key]; ok &&
+           since(recent.when) < duplicateWindow() {
+            msg := fmt.Sprintf("This was posted %s ago, see above",
+                               since(recent.when).Round(time.Second))
+
+            if recent.messageID != "" && c.guildID != "" {
+                msg += fmt.Sprintf(": https://discord.com/channels/%s/%s/%s",
+                                   c.guildID, c.channelID, recent.messageID)
+            } else {
+                msg += "."
+            }
+
+            c.replyPrivate(msg)
+            return false
+        }
+    }
+
+    budget := commandBudget(cmd.name)
+
+    limits := []struct {
+        key         string
+        burst       int
+        perMinute   float64
+        who         string
+    } {
+        {"user:" + c.userID + ":" + cmd.name, budget.UserBurst,
+         budget.UserPerMinute, "You are"},
+        {"channel:" + c.channelID + ":" + cmd.name, budget.ChannelBurst,
+         budget.ChannelPerMinute, "This channel is"},
+    }
+
+    for _, limit := range limits {
+        b := rateLimiter.bucket(limit.key, limit.burst)
+
+        ok, wait := b.take(limit.burst, limit.perMinute)
+
+        if ok {
+            continue
+        }
+
+        /* Only complain once, otherwise we're the ones spamming */
+        if !b.warned {
+            b.warned = true
+
+            c.replyPrivate(fmt.Sprintf("%s using `%s%s` too often - try " +
+                                       "again in %s.", limit.who,
+                                       commandPrefix(), cmd.name,
+                                       wait.Round(time.Second)))
+        }
+
+        return false
+    }
+
+    return true
+}
+
+/* Remembers where we answered the command, so repeats can link to it */
+func recordReply(c *CommandContext, cmd *Command, args []string) {
+    if !cmd.dedupe {
+        return
+    }
+
+    rateLimiter.Lock()
+    defer rateLimiter.Unlock()
+
+    rateLimiter.recent[duplicateKey(c, cmd, args)] = RecentReply {
+        when: now(),
+        messageID: c.firstMessageID,
+    }
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+/* A command the bot understands, for both text and slash commands */
+type Command struct {
+    name                string
+    aliases             []string
+    /* The arguments, as shown in the help, e.g. "<pool>" */
+    usage               string
+    /* One line summary for /help */
+    description         string
+    /* Longer explanation for /help <command> */
+    details             string
+    /* How many arguments the command takes. A maxArgs of -1 means there is
+       no limit */
+    minArgs             int
+    maxArgs             int
+    /* Who is allowed to use it */
+    level               PermissionLevel
+    /* Verified operators can use it too, on their own pools */
+    verifiedOperators   bool
+    /* If the same command is run again in the same channel shortly after,
+       link to the last answer instead of posting it again */
+    dedupe              bool
+    /* Only the person who ran it sees the answer. Needed up front, in case
+       we have to tell discord to wait before we know what to reply */
+    private             bool
+    handler             func(c *CommandContext, args []string)
+}
+
+/* Every command, in the order they are shown in /help. Filled in by
+   init(), because /help needs to read it */
+var commands []*Command
+
+func init() {
+    commands = []*Command {
+        {
+            name: "help",
+            usage: "[command]",
+            description: "Display this help message",
+            details: "Give a command to see more about it, e.g. " +
+                     "`help watch`.",
+            minArgs: 0,
+            maxArgs: 1,
+            private: true,
+            handler: helpCommand,
+        },
+        {
+            name: "heights",
+            aliases: []string{"status"},
+            description: "Display the heights of all known pools",
+            dedupe: true,
+            handler: heightsCommand,
+        },
+        {
+            name: "height",
+            usage: "[pool]",
+            description: "Display the median height, or the height of [pool]",
+            details: "The pool can be part of its name, e.g. `height " +
+                     "turtlepool`.",
+            minArgs: 0,
+            maxArgs: 1,
+            handler: heightCommand,
+        },
+        {
+            name: "pool",
+            usage: "<pool>",
+            description: "Display everything we know about <pool>",
+            minArgs: 1,
+            maxArgs: 1,
+            handler: poolCommand,
+        },
+        {
+            name: "forked",
+            description: "Display any forked pools",
+            dedupe: true,
+            handler: forkedCommand,
+        },
+        {
+            name: "lastfound",
+            description: "Display the time since the last block was found",
+            handler: lastFoundCommand,
+        },
+        {
+            name: "watch",
+            usage: "<pool...|all> [only <alerts>]",
+            description: "Get sent notifications about <pool>",
+            details: "You will be pinged when the pool goes down, forks, " +
+                     "or recovers. Give several pools, or `all`, to watch " +
+                     "them all at once. Add `only` and some of `api`, " +
+                     "`fork` or `stale` to only be pinged when the API is " +
+                     "down, the pool is ahead of the median, or it is " +
+                     "stuck behind it, e.g. `watch turtlepool only api," +
+                     "stale`.\n\nUse `watch <pool> email <address>` to " +
+                     "be sent them by email instead. Only works in the " +
+                     "#stats channel.",
+            minArgs: 1,
+            maxArgs: -1,
+            private: true,
+            handler: watchCommand,
+        },
+        {
+            name: "unwatch",
+            usage: "<pool...|all>",
+            description: "Stop getting notifications about <pool>",
+            details: "Use `unwatch <pool> email <address>` to stop " +
+                     "emailing that address. Only works in the #stats " +
+                     "channel.",
+            minArgs: 1,
+            maxArgs: -1,
+            private: true,
+            handler: unwatchCommand,
+        },
+        {
+            name: "mywatches",
+            description: "Display the pools you are watching",
+            private: true,
+            handler: myWatchesCommand,
+        },
+        {
+            name: "verify",
+            usage: "<code>",
+            description: "Confirm your email address",
+            details: "Use the code from the email sent by `watch <pool> " +
+                     "email <address>`.",
+            minArgs: 1,
+            maxArgs: 1,
+            private: true,
+            handler: verifyCommand,
+        },
+        {
+            name: "claim",
+            usage: "<pool> [verify]",
+            description: "Prove you run <pool>",
+            details: "You will be given a token to publish on the pool " +
+                     "website or API. Once it's there, add `verify` to " +
+                     "check it. Verified operators can silence their pool " +
+                     "and change its fork threshold.",
+            minArgs: 1,
+            maxArgs: 2,
+            private: true,
+            handler: claimCommand,
+        },
+        {
+            name: "unclaim",
+            usage: "<pool>",
+            description: "Stop being a verified operator of <pool>",
+            minArgs: 1,
+            maxArgs: 1,
+            private: true,
+            handler: unclaimCommand,
+        },
+        {
+            name: "threshold",
+            usage: "<pool> [blocks|default]",
+            description: "Show or change how far <pool> can be from the " +
+                         "median",
+            minArgs: 1,
+            maxArgs: 2,
+            level: levelOperator,
+            verifiedOperators: true,
+            handler: thresholdCommand,
+        },
+        {
+            name: "refresh",
+            description: "Update the pools list and heights now",
+            level: levelAdmin,
+            private: true,
+            handler: refreshCommand,
+        },
+        {
+            name: "pooladd",
+            usage: "<url> <api> <type>",
+            description: "Start checking a pool that isn't in the pools " +
+                         "list",
+            details: "The type is forknote or node.js. The pool is saved " +
+                     "in the local pool overrides.",
+            minArgs: 3,
+            maxArgs: 3,
+            level: levelAdmin,
+            private: true,
+            handler: poolAddCommand,
+        },
+        {
+            name: "poolremove",
+            usage: "<pool>",
+            description: "Stop checking <pool>",
+            details: "The pool is left out of the pools list until it is " +
+                     "added again with `pooladd`.",
+            minArgs: 1,
+            maxArgs: 1,
+            level: levelAdmin,
+            private: true,
+            handler: poolRemoveCommand,
+        },
+        {
+            name: "pooledit",
+            usage: "<pool> api=<url> [type=<type>]",
+            description: "Change the api or type of <pool>",
+            details: "The change is saved in the local pool overrides, so " +
+                     "it is kept when the pools list is downloaded again.",
+            minArgs: 2,
+            maxArgs: 3,
+            level: levelAdmin,
+            private: true,
+            handler: poolEditCommand,
+        },
+        {
+            name: "poolsjson",
+            description: "Display any problems with the pools lists",
+            details: "Entries missing their api or type, with an unknown " +
+                     "type or bad api, or listed twice, are left out. " +
+                     "An api without a trailing slash is fixed up.",
+            level: levelAdmin,
+            private: true,
+            handler: poolsJSONCommand,
+        },
+        {
+            name: "silence",
+            usage: "<pool|all> [duration]",
+            description: "Stop alerts about <pool> for a while",
+            details: "The duration defaults to an hour, and is written " +
+                     "like 30m or 2h. Only admins can silence a pool for " +
+                     "more than 24 hours. Use `all` to silence every " +
+                     "alert, including the stuck chain alert.",
+            minArgs: 1,
+            maxArgs: 2,
+            level: levelOperator,
+            verifiedOperators: true,
+            handler: silenceCommand,
+        },
+        {
+            name: "unsilence",
+            usage: "<pool|all>",
+            description: "Turn the alerts about <pool> back on",
+            minArgs: 1,
+            maxArgs: 1,
+            level: levelOperator,
+            verifiedOperators: true,
+            handler: unsilenceCommand,
+        },
+    }
+}
+
+/* The text commands start with this, e.g. /heights */
+func commandPrefix() string {
+    if config.CommandPrefix == "" {
+        return "/"
+    }
+
+    return config.CommandPrefix
+}
+
+func findCommand(name string) *Command {
+    name = strings.ToLower(name)
+
+    for _, cmd := range commands {
+        if cmd.name == name || elem(name, cmd.aliases) {
+            return cmd
+        }
+    }
+
+    return nil
+}
+
+func commandUsage(cmd *Command) string {
+    usage := commandPrefix() + cmd.name
+
+    if cmd.usage != "" {
+        usage += " " + cmd.usage
+    }
+
+    return usage
+}
+
+/* Parses a text command and runs it. Returns false if the message wasn't a
+   command */
+func runTextCommand(c *CommandContext, message string) bool {
+    if !strings.HasPrefix(message, commandPrefix()) {
+        return false
+    }
+
+    fields := strings.Fields(strings.TrimPrefix(message, commandPrefix()))
+
+    if len(fields) == 0 {
+        return false
+    }
+
+    cmd := findCommand(fields[0])
+
+    /* Probably meant for another bot */
+    if cmd == nil {
+        return false
+    }
+
+    runCommand(c, cmd, fields[1:])
+
+    return true
+}
+
+func runCommand(c *CommandContext, cmd *Command, args []string) {
+    lockPools(c, cmd)
+    defer poolsLock.Unlock()
+
+    if !checkPermission(c, cmd) {
+        return
+    }
+
+    if len(args) < cmd.minArgs ||
+       (cmd.maxArgs != -1 && len(args) > cmd.maxArgs) {
+        c.replyPrivate(fmt.Sprintf("Usage: `%s` - %s", commandUsage(cmd),
+                                   cmd.description))
+        return
+    }
+
+    if !checkRateLimit(c, cmd, args) {
+        return
+    }
+
+    recoverPanics("command " + cmd.name, func() {
+        cmd.handler(c, args)
+    })
+
+    recordReply(c, cmd, args)
+}
+
+/* Commands read and change the pools, so they wait for a running cycle to
+   finish. That can take longer than discord waits for an answer, so we
+   tell it we're working on it first */
+func lockPools(c *CommandContext, cmd *Command) {
+    if poolsLock.TryLock() {
+        return
+    }
+
+    c.deferReply(cmd.private)
+
+    poolsLock.Lock()
+}
+
+func helpCommand(c *CommandContext, args []string) {
+    if len(args) == 1 {
+        cmd := findCommand(strings.TrimPrefix(args[0], commandPrefix()))
+
+        if cmd == nil {
+            c.replyPrivate(fmt.Sprintf("Unknown command %s - type `%shelp` " +
+                                       "to view all commands.", args[0],
+                                       commandPrefix()))
+            return
+        }
+
+        msg := fmt.Sprintf("```%s\n\n%s", commandUsage(cmd), cmd.description)
+
+        if cmd.details != "" {
+            msg += "\n\n" + cmd.details
+        }
+
+        if cmd.level != levelPublic {
+            msg += fmt.Sprintf("\n\nNeeds: %s", cmd.level)
+
+            if cmd.verifiedOperators {
+                msg += ", or a verified operator of the pool"
+            }
+        }
+
+        if len(cmd.aliases) != 0 {
+            msg += "\n\nAliases: " + commandPrefix() +
+                   strings.Join(cmd.aliases, ", " + commandPrefix())
+        }
+
+        c.replyPrivate(msg + "```")
+        return
+    }
+
+    msg := "```\nAvailable commands:\n\n"
+
+    for _, cmd := range commands {
+        /* Don't clutter the help with commands they can't use */
+        if !hasPermission(c, cmd) {
+            continue
+        }
+
+        msg += fmt.Sprintf("%-32s %s\n", commandUsage(cmd), cmd.description)
+    }
+
+    c.replyPrivate(msg + "```")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "embed"
+    "io/fs"
+    "net/http"
+)
+
+/* The dashboard */
+//go:embed web
+var webFiles embed.FS
+
+type HttpConfig struct {
+    /* The address to serve /metrics, the JSON API and the dashboard on,
+       e.g. ":9100". Leave empty to not run the server */
+    Listen      string `json:"listen"`
+    /* Which websites can use the JSON API from the browser. Defaults to
+       any of them */
+    CorsOrigin  string `json:"corsOrigin"`
+}
+
+/* Starts the HTTP server in the background, if it's turned on */
+func startHttpServer() {
+    if config.Http.Listen == "" {
+        return
+    }
+
+    mux := http.NewServeMux()
+
+    mux.HandleFunc("/metrics", metricsHandler)
+    mux.HandleFunc("/api/pools", poolsHandler)
+    mux.HandleFunc("/api/pools/", poolsHandler)
+    mux.HandleFunc("/api/network", networkHandler)
+    mux.HandleFunc("/api/incidents", incidentsHandler)
+    mux.HandleFunc("/api/events", eventsHandler)
+    mux.HandleFunc("/healthz", healthzHandler)
+    mux.HandleFunc("/readyz", readyzHandler)
+
+    web, err := fs.Sub(webFiles, "web")
+
+    if err != nil {
+        logError("Failed to load the dashboard", Fields{"error": err})
+    } else {
+        mux.Handle("/", http.FileServer(http.FS(web)))
+    }
+
+    go func() {
+        logInfo("Serving HTTP", Fields{"listen": config.Http.Listen})
+
+        err := http.ListenAndServe(config.Http.Listen, mux)
+
+        logError("HTTP server stopped", Fields{"error": err})
+    }()
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+    "github.com/bwmarrin/discordgo"
+    "strings"
+)
+
+/* Discord only lets us offer this many autocomplete choices */
+const autocompleteLimit = 25
+
+/* And each choice can only be this long */
+const autocompleteValueLimit = 100
+
+/* The pool argument, shared by /height, /watch and /unwatch */
+func poolOption(required bool) *discordgo.ApplicationCommandOption {
+    return &discordgo.ApplicationCommandOption {
+        Type: discordgo.ApplicationCommandOptionString,
+        Name: "pool",
+        Description: "The pool, e.g. turtlepool.space",
+        Required: required,
+        Autocomplete: true,
+    }
+}
+
+func poolTypeOption(required bool) *discordgo.ApplicationCommandOption {
+    return &discordgo.ApplicationCommandOption {
+        Type: discordgo.ApplicationCommandOptionString,
+        Name: "type",
+        Description: "The pool software",
+        Required: required,
+        Choices: []*discordgo.ApplicationCommandOptionChoice {
+            {Name: "forknote", Value: "forknote"},
+            {Name: "node.js", Value: "node.js"},
+        },
+    }
+}
+
+/* A pool, or all of them */
+func poolOrAllOption() *discordgo.ApplicationCommandOption {
+    option := poolOption(true)
+    option.Description = "The pool, or all"
+
+    return option
+}
+
+/* Several pools, separated by spaces, or all */
+func poolsOption() *discordgo.ApplicationCommandOption {
+    option := poolOption(true)
+    option.Description = "The pools, separated by spaces, or all"
+
+    return option
+}
+
+func emailOption(description string) *discordgo.ApplicationCommandOption {
+    return &discordgo.ApplicationCommandOption {
+        Type: discordgo.ApplicationCommandOptionString,
+        Name: "email",
+        Description: description,
+    }
+}
+
+var slashCommands = []*discordgo.ApplicationCommand {
+    {
+        Name: "help",
+        Description: "Display the help message",
+        Options: []*discordgo.ApplicationCommandOption {
+            {
+                Type: discordgo.ApplicationCommandOptionString,
+                Name: "command",
+                Description: "Show more about this command",
+            },
+        },
+    },
+    {
+        Name: "heights",
+        Description: "Display the heights of all known pools",
+    },
+    {
+        Name: "status",
+        Description: "An alias for /heights",
+    },
+    {
+        Name: "height",
+        Description: "Display the median height, or the height of a pool",
+        Options: []*discordgo.ApplicationCommandOption {
+            poolOption(false),
+        },
+    },
+    {
+        Name: "pool",
+        Description: "Display everything we know about a pool",
+        Options: []*discordgo.ApplicationCommandOption {
+            poolOption(true),
+        },
+    },
+    {
+        Name: "forked",
+        Description: "Display any forked pools",
+    },
+    {
+        Name: "lastfound",
+        Description: "Display the time since the last block was found",
+    },
+    {
+        Name: "watch",
+        Description: "Watch a pool so you can be sent notifications",
+        Options: []*discordgo.ApplicationCommandOption {
+            poolsOption(),
+            {
+                Type: discordgo.ApplicationCommandOptionString,
+                Name: "only",
+                Description: "Only these alerts, e.g. api,stale",
+            },
+            emailOption("Send the notifications to this email address " +
+                        "instead"),
+        },
+    },
+    {
+        Name: "unwatch",
+        Description: "Stop watching a pool so you no longer get sent " +
+                     "notifications",
+        Options: []*discordgo.ApplicationCommandOption {
+            poolsOption(),
+            emailOption("Stop emailing this address instead"),
+        },
+    },
+    {
+        Name: "mywatches",
+        Description: "Display the pools you are watching",
+    },
+    {
+        Name: "claim",
+        Description: "Prove you run a pool",
+        Options: []*discordgo.ApplicationCommandOption {
+            poolOption(true),
+            {
+                Type: discordgo.ApplicationCommandOptionString,
+                Name: "verify",
+                Description: "Check the token has been published",
+                Choices: []*discordgo.ApplicationCommandOptionChoice {
+                    {Name: "verify", Value: "verify"},
+                },
+            },
+        },
+    },
+    {
+        Name: "unclaim",
+        Description: "Stop being a verified operator of a pool",
+        Options: []*discordgo.ApplicationCommandOption {
+            poolOption(true),
+        },
+    },
+    {
+        Name: "threshold",
+        Description: "Show or change how far a pool can be from the median",
+        Options: []*discordgo.ApplicationCommandOption {
+            poolOption(true),
+            {
+                Type: discordgo.ApplicationCommandOptionString,
+                Name: "blocks",
+                Description: "The number of blocks, or default",
+            },
+        },
+    },
+    {
+        Name: "refresh",
+        Description: "Update the pools list and heights now",
+    },
+    {
+        Name: "pooladd",
+        Description: "Start checking a pool that isn't in the pools list",
+        Options: []*discordgo.ApplicationCommandOption {
+            {
+                Type: discordgo.ApplicationCommandOptionString,
+                Name: "url",
+                Description: "The pool website, e.g. turtlepool.space",
+                Required: true,
+            },
+            {
+                Type: discordgo.ApplicationCommandOptionString,
+                Name: "api",
+                Description: "Where its API is, e.g. " +
+                             "https://turtlepool.space/api/",
+                Required: true,
+            },
+            poolTypeOption(true),
+        },
+    },
+    {
+        Name: "poolremove",
+        Description: "Stop checking a pool",
+        Options: []*discordgo.ApplicationCommandOption {
+            poolOption(true),
+        },
+    },
+    {
+        Name: "pooledit",
+        Description: "Change the api or type of a pool",
+        Options: []*discordgo.ApplicationCommandOption {
+            poolOption(true),
+            {
+                Type: discordgo.ApplicationCommandOptionString,
+                Name: "api",
+                Description: "Where its API is, e.g. " +
+                             "https://turtlepool.space/api/",
+            },
+            poolTypeOption(false),
+        },
+    },
+    {
+        Name: "poolsjson",
+        Description: "Display any problems with the pools lists",
+    },
+    {
+        Name: "silence",
+        Description: "Stop alerts about a pool for a while",
+        Options: []*discordgo.ApplicationCommandOption {
+            poolOrAllOption(),
+            {
+                Type: discordgo.ApplicationCommandOptionString,
+                Name: "duration",
+                Description: "How long for, e.g. 30m or 2h. Defaults to an " +
+                             "hour",
+            },
+        },
+    },
+    {
+        Name: "unsilence",
+        Description: "Turn the alerts about a pool back on",
+        Options: []*discordgo.ApplicationCommandOption {
+            poolOrAllOption(),
+        },
+    },
+    {
+        Name: "verify",
+        Description: "Confirm your email address",
+        Options: []*discordgo.ApplicationCommandOption {
+            {
+                Type: discordgo.ApplicationCommandOptionString,
+                Name: "code",
+                Description: "The code we emailed you",
+                Required: true,
+            },
+        },
+    },
+}
+
+/* Replaces whatever commands discord knows about with ours */
+func registerSlashCommands(s *discordgo.Session) error {
+    _, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, "",
+                                                slashCommands)
+
+    return err
+}
+
+func interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+    client := &DiscordClient{session: s}
+
+    switch i.Type {
+    case discordgo.InteractionApplicationCommand:
+        handleSlashCommand(client, i)
+    case discordgo.InteractionApplicationCommandAutocomplete:
+        handleAutocomplete(client, i)
+    }
+}
+
+func handleSlashCommand(s ChatClient, i *discordgo.InteractionCreate) {
+    data := i.ApplicationCommandData()
+
+    /* Commands in DMs have no member */
+    if i.Member == nil {
+        return
+    }
+
+    c := &CommandContext{session: s, channelID: i.ChannelID,
+                         guildID: i.GuildID, userID: i.Member.User.ID,
+                         level: permissionLevel(s, i.GuildID, i.Member.Roles),
+                         interaction: i.Interaction}
+
+    cmd := findCommand(data.Name)
+
+    if cmd == nil {
+        logWarn("Unknown slash command", Fields{"command": data.Name})
+        return
+    }
+
+    /* Turn the options back into the same arguments as the text command.
+       The pools always come first */
+    args := make([]string, 0)
+    rest := make([]string, 0)
+
+    for _, o := range data.Options {
+        value := strings.TrimSpace(o.StringValue())
+
+        switch o.Name {
+        case "pool":
+            args = append(args, strings.Fields(value)...)
+        case "email", "only":
+            rest = append(rest, o.Name)
+            rest = append(rest, strings.Fields(value)...)
+        case "api", "type":
+            /* /pooledit takes them as api=<url> */
+            if cmd.name == "pooledit" {
+                value = o.Name + "=" + value
+            }
+
+            rest = append(rest, value)
+        default:
+            rest = append(rest, value)
+        }
+    }
+
+    args = append(args, rest...)
+
+    runCommand(c, cmd, args)
+}
+
+/* Suggests pools matching what has been typed so far */
+func handleAutocomplete(s ChatClient, i *discordgo.InteractionCreate) {
+    typed := ""
+
+    /* What they've typed before the pool being completed, if they're giving
+       several */
+    before := ""
+
+    for _, o := range i.ApplicationCommandData().Options {
+        if o.Focused {
+            typed = o.StringValue()
+        }
+    }
+
+    if index := strings.LastIndex(typed, " "); index != -1 {
+        before = typed[:index + 1]
+        typed = typed[index + 1:]
+    }
+
+    typed = normalizePool(typed)
+
+    /* Discord only waits a few seconds for the suggestions, so rather than
+       wait for a running cycle, we offer none */
+    urls := make([]string, 0)
+
+    if poolsLock.TryLock() {
+        for _, v := range globalInfo.pools {
+            urls = append(urls, v.url)
+        }
+
+        poolsLock.Unlock()
+    }
+
+    choices := make([]*discordgo.ApplicationCommandOptionChoice, 0)
+
+    for _, url := range urls {
+        if len(choices) >= autocompleteLimit {
+            break
+        }
+
+        if strings.Contains(normalizePool(url), typed) &&
+           len(before + url) <= autocompleteValueLimit {
+            choices = append(choices, &discordgo.ApplicationCommandOptionChoice {
+                Name: before + url,
+                Value: before + url,
+            })
+        }
+    }
+
+    err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse {
+        Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+        Data: &discordgo.InteractionResponseData {
+            Choices: choices,
+        },
+    })
+
+    if err != nil {
+        logWarn("Failed to send autocomplete choices", Fields{"error": err})
+    }
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+    "github.com/bwmarrin/discordgo"
+    "errors"
+    "os"
+    "bufio"
+    "strings"
+)
+
+/* Where we remember the status board messages, so we can keep editing the
+   same ones after a restart */
+const statusBoardFile string = "statusboard.txt"
+
+/* The IDs of the messages making up the status board, one per page */
+var statusBoardMessages []string
+
+func getStatusBoard() ([]string, error) {
+    messages := make([]string, 0)
+
+    /* File exists */
+    if _, err := os.Stat(statusBoardFile); err == nil {
+        file, err := os.Open(statusBoardFile)
+
+        if err != nil {
+            return messages, err
+        }
+
+        defer file.Close()
+
+        scanner := bufio.NewScanner(file)
+
+        for scanner.Scan() {
+            if line := strings.TrimSpace(scanner.Text()); line != "" {
+                messages = append(messages, line)
+            }
+        }
+
+        if err := scanner.Err(); err != nil {
+            logError("Failed to read status board",
+                     Fields{"file": statusBoardFile, "error": err})
+            return messages, err
+        }
+    }
+
+    return messages, nil
+}
+
+func writeStatusBoard() {
+    file, err := os.Create(statusBoardFile)
+
+    if err != nil {
+        logError("Failed to open status board file",
+                 Fields{"file": statusBoardFile, "error": err})
+        return
+    }
+
+    defer file.Close()
+
+    for _, id := range statusBoardMessages {
+        file.WriteString(id + "\n")
+    }
+
+    file.Sync()
+}
+
+/* Edits the status board in place with the latest heights, adding or
+   removing pages as the number of pools changes */
+func updateStatusBoard(s ChatClient) {
+    pages := heightsMessages()
+
+    changed := false
+
+    for i, page := range pages {
+        if i < len(statusBoardMessages) {
+            embeds := page.Embeds
+
+            edit := discordgo.NewMessageEdit(poolsChannel,
+                                             statusBoardMessages[i])
+            edit.Content = &page.Content
+            edit.Embeds = &embeds
+
+            _, err := s.ChannelMessageEditComplex(edit)
+
+            if err == nil {
+                continue
+            }
+
+            logWarn("Failed to edit status board",
+                    Fields{"message": statusBoardMessages[i], "error": err})
+            metrics.discordSendFailed()
+
+            /* Probably just discord having a moment, try again next cycle
+               rather than leaving the old one behind */
+            if !isUnknownMessage(err) {
+                continue
+            }
+
+            /* Deleted by someone, post a new one in its place */
+        }
+
+        msg, err := s.ChannelMessageSendComplex(poolsChannel, page)
+
+        if err != nil {
+            logWarn("Failed to post status board", Fields{"error": err})
+            metrics.discordSendFailed()
+            break
+        }
+
+        if err := s.ChannelMessagePin(poolsChannel, msg.ID); err != nil {
+            logWarn("Failed to pin status board", Fields{"error": err})
+        }
+
+        if i < len(statusBoardMessages) {
+            statusBoardMessages[i] = msg.ID
+        } else {
+            statusBoardMessages = append(statusBoardMessages, msg.ID)
+        }
+
+        changed = true
+    }
+
+    /* Fewer pages than last time, remove the spare ones */
+    for len(statusBoardMessages) > len(pages) {
+        last := statusBoardMessages[len(statusBoardMessages) - 1]
+
+        if err := s.ChannelMessageDelete(poolsChannel, last); err != nil {
+            logWarn("Failed to delete status board page",
+                    Fields{"message": last, "error": err})
+        }
+
+        statusBoardMessages = statusBoardMessages[:len(statusBoardMessages) - 1]
+
+        changed = true
+    }
+
+    if changed {
+        writeStatusBoard()
+    }
+}
+
+/* Whether discord told us the message doesn't exist */
+func isUnknownMessage(err error) bool {
+    var restErr *discordgo.RESTError
+
+    if !errors.As(err, &restErr) || restErr.Message == nil {
+        return false
+    }
+
+    return restErr.Message.Code == discordgo.ErrCodeUnknownMessage
+}
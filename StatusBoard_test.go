@@ -0,0 +1,89 @@
+package main
+
+import (
+    "testing"
+    "net/http"
+    "github.com/bwmarrin/discordgo"
+)
+
+func discordError(status int, code int) error {
+    return &discordgo.RESTError {
+        Response: &http.Response{StatusCode: status},
+        Message: &discordgo.APIErrorMessage{Code: code},
+    }
+}
+
+func TestStatusBoard(t *testing.T) {
+    chat := setupTest(t)
+
+    config.StatusBoard = true
+
+    globalInfo.modeHeight = 1000
+    globalInfo.pools = []PoolInfo{testPool("a.example", 1000),
+                                  testPool("b.example", 0)}
+
+    updateStatusBoard(chat)
+
+    if len(statusBoardMessages) != 1 || len(chat.pinned) != 1 ||
+       chat.pinned[0] != statusBoardMessages[0] {
+        t.Fatalf("Board not posted and pinned: %v %v", statusBoardMessages,
+                 chat.pinned)
+    }
+
+    board := statusBoardMessages[0]
+
+    /* Read back after a restart */
+    if saved, _ := getStatusBoard(); len(saved) != 1 || saved[0] != board {
+        t.Errorf("Board not saved: %v", saved)
+    }
+
+    before := len(chat.messages())
+
+    updateStatusBoard(chat)
+
+    messages := chat.messages()[before:]
+
+    if len(messages) != 1 || messages[0].messageID != board {
+        t.Errorf("Expected the board to be edited in place, got %d messages",
+                 len(messages))
+    }
+
+    expectText(t, messages, "b.example")
+    expectText(t, messages, "Api Down")
+}
+
+func TestStatusBoardEditFails(t *testing.T) {
+    chat := setupTest(t)
+
+    config.StatusBoard = true
+
+    globalInfo.pools = []PoolInfo{testPool("a.example", 1000)}
+
+    updateStatusBoard(chat)
+
+    board := statusBoardMessages[0]
+
+    /* A blip shouldn't leave the old board behind and pin a new one */
+    chat.editErr = discordError(http.StatusBadGateway, 0)
+
+    before := len(chat.messages())
+
+    updateStatusBoard(chat)
+
+    if len(chat.messages()) != before || len(chat.pinned) != 1 ||
+       statusBoardMessages[0] != board {
+        t.Errorf("Reposted the board after a transient error")
+    }
+
+    /* Someone deleted it, so it needs posting again */
+    chat.editErr = discordError(http.StatusNotFound,
+                                discordgo.ErrCodeUnknownMessage)
+
+    updateStatusBoard(chat)
+
+    if len(chat.pinned) != 2 || statusBoardMessages[0] == board ||
+       chat.pinned[1] != statusBoardMessages[0] {
+        t.Errorf("Board not reposted after being deleted: %v %v",
+                 statusBoardMessages, chat.pinned)
+    }
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "net/url"
+)
+
+/* Something wrong with an entry in a pools list */
+type PoolProblem struct {
+    source      string
+    url         string
+    reason      string
+    /* Whether we left the pool out, rather than fixing it up */
+    skipped     bool
+}
+
+/* The problems found the last time the pools list was read, for
+   /poolsjson */
+type PoolProblems struct {
+    sync.Mutex
+    problems    []PoolProblem
+    pools       int
+}
+
+var poolProblems PoolProblems
+
+func (p *PoolProblems) set(problems []PoolProblem, pools int) {
+    p.Lock()
+    defer p.Unlock()
+
+    p.problems = problems
+    p.pools = pools
+}
+
+func (p *PoolProblems) list() ([]PoolProblem, int) {
+    p.Lock()
+    defer p.Unlock()
+
+    return append([]PoolProblem{}, p.problems...), p.pools
+}
+
+func knownPoolType(poolType string) bool {
+    return poolType == "forknote" || poolType == "node.js"
+}
+
+/* Checks the api is an absolute http or https url, and makes sure it ends
+   in a slash, so the paths we add go on the end rather than replacing the
+   last part */
+func normalizeApi(api string) (string, error) {
+    u, err := url.Parse(strings.TrimSpace(api))
+
+    if err != nil {
+        return "", err
+    }
+
+    if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return "", errors.New("not an http or https url")
+    }
+
+    if !strings.HasSuffix(u.Path, "/") {
+        u.Path += "/"
+    }
+
+    return u.String(), nil
+}
+
+/* The url of something under the pool api, e.g. apiURL(api, "stats") */
+func apiURL(api string, path string) string {
+    base, err := url.Parse(api)
+
+    if err != nil {
+        return api + path
+    }
+
+    if !strings.HasSuffix(base.Path, "/") {
+        base.Path += "/"
+    }
+
+    ref, err := url.Parse(path)
+
+    if err != nil {
+        return api + path
+    }
+
+    return base.ResolveReference(ref).String()
+}
+
+/* Leaves out any pools listed more than once in the same list. Different
+   lists listing the same pool is how they are merged */
+func dedupePools(source string, list []Pool) ([]Pool, []PoolProblem) {
+    pools := make([]Pool, 0)
+    problems := make([]PoolProblem, 0)
+
+    for _, pool := range list {
+        if pool.Url != "" && findListedPool(pools, pool.Url) != -1 {
+            problems = append(problems, PoolProblem{source: source,
+                url: pool.Url, reason: "listed more than once, only the " +
+                                       "first is used", skipped: true})
+            continue
+        }
+
+        pools = append(pools, pool)
+    }
+
+    return pools, problems
+}
+
+/* Leaves out the pools we can't check, and tidies up the rest */
+func validatePools(list []Pool) ([]Pool, []PoolProblem) {
+    pools := make([]Pool, 0)
+    problems := make([]PoolProblem, 0)
+
+    for _, pool := range list {
+        problem := PoolProblem{source: pool.source, url: pool.Url,
+                               skipped: true}
+
+        switch {
+        case pool.Url == "":
+            problem.reason = "missing url"
+        case pool.Type == "":
+            problem.reason = "missing type"
+        case !knownPoolType(pool.Type):
+            problem.reason = fmt.Sprintf("unknown type %s", pool.Type)
+        case pool.Api == "":
+            problem.reason = "missing api"
+        }
+
+        if problem.reason != "" {
+            problems = append(problems, problem)
+            continue
+        }
+
+        api, err := normalizeApi(pool.Api)
+
+        if err != nil {
+            problem.reason = fmt.Sprintf("api %s isn't valid: %s", pool.Api,
+                                         err)
+            problems = append(problems, problem)
+            continue
+        }
+
+        if !strings.HasSuffix(strings.TrimSpace(pool.Api), "/") {
+            problems = append(problems, PoolProblem{source: pool.source,
+                url: pool.Url, reason: fmt.Sprintf("api %s has no trailing " +
+                                                   "slash", pool.Api)})
+        }
+
+        pool.Api = api
+
+        pools = append(pools, pool)
+    }
+
+    return pools, problems
+}
+
+/* Keeps the problems for /poolsjson, and logs them */
+func reportPoolProblems(problems []PoolProblem, pools int) {
+    sort.SliceStable(problems, func(i, j int) bool {
+        return problems[i].source < problems[j].source
+    })
+
+    for _, problem := range problems {
+        logWarn("Problem in pools json",
+                Fields{"url": problem.source, "pool": problem.url,
+                       "problem": problem.reason,
+                       "skipped": problem.skipped})
+    }
+
+    poolProblems.set(problems, pools)
+}
+
+/* /poolsjson - what's wrong with the pools lists, so it can be fixed
+   upstream */
+func poolsJSONCommand(c *CommandContext, args []string) {
+    problems, pools := poolProblems.list()
+
+    if len(problems) == 0 {
+        c.replyPrivate(fmt.Sprintf("No problems found in the pools lists " +
+                                   "(%d pools).", pools))
+        return
+    }
+
+    msg := fmt.Sprintf("%d problems found in the pools lists (%d pools " +
+                       "used):\n```", len(problems), pools)
+
+    source := ""
+
+    for _, problem := range problems {
+        line := ""
+
+        if problem.source != source {
+            source = problem.source
+            line += "\n" + source + "\n"
+        }
+
+        name := problem.url
+
+        if name == "" {
+            name = "(no url)"
+        }
+
+        line += fmt.Sprintf("  %s: %s", name, problem.reason)
+
+        if problem.skipped {
+            line += " - skipped"
+        }
+
+        /* Message length will exceed discord limit, send what we have so
+           far then continue */
+        if len(msg) + len(line) >= messageLimit - 200 {
+            c.replyPrivate(msg + "```")
+            msg = "```"
+        }
+
+        msg += line + "\n"
+    }
+
+    c.replyPrivate(msg + "```")
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+/* The kinds of alert people can pick to be pinged about */
+const (
+    /* The pool API can't be reached */
+    eventApi = "api"
+    /* The pool is ahead of the median, i.e. on its own fork */
+    eventFork = "fork"
+    /* The pool is behind the median, i.e. not finding new blocks */
+    eventStale = "stale"
+)
+
+var allEvents = []string{eventApi, eventFork, eventStale}
+
+/* A pool that is too far from the median is either ahead of it or stuck
+   behind it */
+func heightEvent(v *PoolInfo) string {
+    if v.height < globalInfo.modeHeight {
+        return eventStale
+    }
+
+    return eventFork
+}
+
+/* Whether the user wants to be pinged about the event. No event means we
+   don't know, so we ping them */
+func wantsEvent(v *PoolInfo, userID string, event string) bool {
+    events, ok := v.watchEvents[userID]
+
+    if !ok || event == "" {
+        return true
+    }
+
+    return elem(event, events)
+}
+
+func describeEvents(events []string) string {
+    if len(events) == 0 {
+        return "all alerts"
+    }
+
+    return "only " + strings.Join(events, ", ")
+}
+
+/* Splits /watch <pool> email <address> into the email address, or returns
+   false if the arguments don't make sense */
+func parseEmailArgs(c *CommandContext, cmd string,
+                    args []string) (string, bool) {
+    if len(args) == 3 && strings.ToLower(args[1]) == "email" {
+        return args[2], true
+    }
+
+    c.replyPrivate(fmt.Sprintf("Usage: `%s%s <pool> [email <address>]`",
+                               commandPrefix(), cmd))
+
+    return "", false
+}
+
+func isEmailArgs(args []string) bool {
+    return len(args) > 1 && strings.ToLower(args[1]) == "email"
+}
+
+/* Turns "all" or a list of pools into the pools. If any can't be found, the
+   user has been told why, and we return nil */
+func resolvePools(c *CommandContext, names []string) []*PoolInfo {
+    pools := make([]*PoolInfo, 0)
+
+    if len(names) == 1 && strings.ToLower(names[0]) == "all" {
+        for index, _ := range globalInfo.pools {
+            pools = append(pools, &globalInfo.pools[index])
+        }
+
+        return pools
+    }
+
+    for _, name := range names {
+        v := resolvePool(c, name)
+
+        if v == nil {
+            return nil
+        }
+
+        /* Don't list it twice if they gave it twice */
+        duplicate := false
+
+        for _, p := range pools {
+            if p == v {
+                duplicate = true
+            }
+        }
+
+        if !duplicate {
+            pools = append(pools, v)
+        }
+    }
+
+    return pools
+}
+
+/* Splits <pools...> [only <events>] into the pools and the events. The
+   events can be separated by commas or spaces */
+func parseWatchArgs(c *CommandContext, args []string) ([]*PoolInfo,
+                                                       []string, bool) {
+    names := args
+    events := make([]string, 0)
+
+    for index, arg := range args {
+        if strings.ToLower(arg) != "only" {
+            continue
+        }
+
+        names = args[:index]
+
+        for _, word := range args[index + 1:] {
+            for _, event := range strings.Split(strings.ToLower(word), ",") {
+                if event == "" {
+                    continue
+                }
+
+                if !elem(event, allEvents) {
+                    c.replyPrivate(fmt.Sprintf("Unknown alert %s - pick " +
+                                               "from %s.", event,
+                                               strings.Join(allEvents, ", ")))
+                    return nil, nil, false
+                }
+
+                if !elem(event, events) {
+                    events = append(events, event)
+                }
+            }
+        }
+
+        if len(events) == 0 {
+            c.replyPrivate(fmt.Sprintf("Say which alerts you want after " +
+                                       "`only`, from %s.",
+                                       strings.Join(allEvents, ", ")))
+            return nil, nil, false
+        }
+
+        break
+    }
+
+    if len(names) == 0 {
+        c.replyPrivate(fmt.Sprintf("Usage: `%swatch <pool...|all> [only " +
+                                   "<alerts>]`", commandPrefix()))
+        return nil, nil, false
+    }
+
+    pools := resolvePools(c, names)
+
+    if pools == nil {
+        return nil, nil, false
+    }
+
+    return pools, events, true
+}
+
+/* Long lists of pools won't fit in a message, so just count them */
+const maxListedPools = 10
+
+func poolNames(pools []*PoolInfo) string {
+    if len(pools) > maxListedPools {
+        return fmt.Sprintf("%d pools", len(pools))
+    }
+
+    names := make([]string, 0)
+
+    for _, v := range pools {
+        names = append(names, v.url)
+    }
+
+    return strings.Join(names, ", ")
+}
+
+/* Stores which events the user wants. No events means all of them */
+func setWatchEvents(v *PoolInfo, userID string, events []string) {
+    if v.watchEvents == nil {
+        v.watchEvents = make(map[string][]string)
+    }
+
+    if len(events) == 0 {
+        delete(v.watchEvents, userID)
+    } else {
+        v.watchEvents[userID] = events
+    }
+}
+
+/* /watch <pool...|all> [only <alerts>], or /watch <pool> email <address> */
+func watchCommand(c *CommandContext, args []string) {
+    if !inPoolsChannel(c) {
+        return
+    }
+
+    if isEmailArgs(args) {
+        address, ok := parseEmailArgs(c, "watch", args)
+
+        if !ok {
+            return
+        }
+
+        if v := resolvePool(c, args[0]); v != nil {
+            watchEmail(c, v, address)
+        }
+
+        return
+    }
+
+    pools, events, ok := parseWatchArgs(c, args)
+
+    if !ok {
+        return
+    }
+
+    changed := make([]*PoolInfo, 0)
+
+    for _, v := range pools {
+        current, filtered := v.watchEvents[c.userID]
+
+        /* Already watching exactly this */
+        if elem(c.userID, v.claimees) &&
+           strings.Join(current, ",") == strings.Join(events, ",") &&
+           filtered == (len(events) != 0) {
+            continue
+        }
+
+        if !elem(c.userID, v.claimees) {
+            v.claimees = append(v.claimees, c.userID)
+        }
+
+        setWatchEvents(v, c.userID, events)
+
+        changed = append(changed, v)
+    }
+
+    if len(changed) == 0 {
+        c.replyPrivate(fmt.Sprintf("You are already watching %s for %s!",
+                                   poolNames(pools), describeEvents(events)))
+        return
+    }
+
+    writeClaims()
+
+    c.replyPrivate(fmt.Sprintf("You are watching %s for %s!",
+                               poolNames(changed), describeEvents(events)))
+}
+
+/* /unwatch <pool...|all>, or /unwatch <pool> email <address> */
+func unwatchCommand(c *CommandContext, args []string) {
+    if !inPoolsChannel(c) {
+        return
+    }
+
+    if isEmailArgs(args) {
+        address, ok := parseEmailArgs(c, "unwatch", args)
+
+        if !ok {
+            return
+        }
+
+        if v := resolvePool(c, args[0]); v != nil {
+            unwatchEmail(c, v, address)
+        }
+
+        return
+    }
+
+    pools := resolvePools(c, args)
+
+    if pools == nil {
+        return
+    }
+
+    changed := make([]*PoolInfo, 0)
+
+    for _, v := range pools {
+        if !elem(c.userID, v.claimees) {
+            continue
+        }
+
+        v.claimees = deleteElem(c.userID, v.claimees)
+        delete(v.watchEvents, c.userID)
+
+        changed = append(changed, v)
+    }
+
+    if len(changed) == 0 {
+        c.replyPrivate(fmt.Sprintf("You are not watching %s!",
+                                   poolNames(pools)))
+        return
+    }
+
+    writeClaims()
+
+    c.replyPrivate(fmt.Sprintf("You are no longer watching %s!",
+                               poolNames(changed)))
+}
+
+/* /mywatches - the pools the user is watching, how they are doing, and which
+   alerts they get */
+func myWatchesCommand(c *CommandContext, args []string) {
+    msg := fmt.Sprintf("```%-34s %-10s %-10s %s\n\n", "Pool", "Height",
+                       "Status", "Alerts")
+
+    watching := 0
+
+    for index, _ := range globalInfo.pools {
+        v := &globalInfo.pools[index]
+
+        if !elem(c.userID, v.claimees) {
+            continue
+        }
+
+        watching++
+
+        /* Message length will exceed discord limit, send what we have so
+           far then continue */
+        if len(msg) >= messageLimit - 200 {
+            c.replyPrivate(msg + "```")
+            msg = "```"
+        }
+
+        msg += fmt.Sprintf("%-34s %-10d %-10s %s\n", v.url, v.height,
+                           poolStatus(v),
+                           describeEvents(v.watchEvents[c.userID]))
+    }
+
+    if watching == 0 {
+        c.replyPrivate(fmt.Sprintf("You aren't watching any pools - type " +
+                                   "`%swatch <pool>` in the #stats channel " +
+                                   "to start.", commandPrefix()))
+        return
+    }
+
+    c.replyPrivate(msg + "```")
+}
@@ -0,0 +1,237 @@
+/* Shared by the pools table and the pool pages */
+
+function getJSON(path) {
+    return fetch(path).then(function(response) {
+        if (!response.ok) {
+            throw new Error(path + " returned " + response.status);
+        }
+
+        return response.json();
+    });
+}
+
+/* Calls back with the network and pools after every cycle */
+function listen(callback) {
+    var events = new EventSource("api/events");
+
+    events.addEventListener("update", function(e) {
+        callback(JSON.parse(e.data));
+    });
+}
+
+function cell(content) {
+    var td = document.createElement("td");
+
+    if (content instanceof Node) {
+        td.appendChild(content);
+    } else {
+        td.textContent = content;
+    }
+
+    return td;
+}
+
+function statusClass(status) {
+    return "status-" + status.toLowerCase().replace(" ", "-");
+}
+
+function statusEmoji(status) {
+    switch (status) {
+        case "Ok":
+            return "\u{1F7E2}";
+        case "Forked":
+            return "\u{1F7E0}";
+        default:
+            return "\u{1F534}";
+    }
+}
+
+/* The same format the bot uses, e.g. 5 minutes, 3 seconds ago */
+function timeAgo(when) {
+    if (!when) {
+        return "Never";
+    }
+
+    var seconds = Math.max(0, Math.round((Date.now() - Date.parse(when)) /
+                                         1000));
+
+    if (seconds < 60) {
+        return seconds + " seconds ago";
+    }
+
+    if (seconds < 3600) {
+        return Math.floor(seconds / 60) + " minutes, " + seconds % 60 +
+               " seconds ago";
+    }
+
+    if (seconds < 86400) {
+        return Math.floor(seconds / 3600) + " hours, " +
+               Math.floor(seconds % 3600 / 60) + " minutes ago";
+    }
+
+    return Math.floor(seconds / 86400) + " days, " +
+           Math.floor(seconds % 86400 / 3600) + " hours ago";
+}
+
+function renderNetwork(network) {
+    var text = "Median height " + network.modeHeight + " · Block last " +
+               "found " + timeAgo(network.lastFound) + " · " +
+               network.poolsOk + " of " + network.pools + " pools ok";
+
+    if (network.stuck) {
+        text = "\u{1F534} The chain looks stuck! " + text;
+    }
+
+    var element = document.getElementById("network");
+    element.textContent = text;
+    element.classList.toggle("stuck", network.stuck);
+}
+
+var incidentNames = {
+    api: "API down",
+    fork: "Forked ahead of the network",
+    stale: "Stuck behind the network",
+    stuck: "Chain stuck",
+};
+
+/* Fills the list with the incidents, only those for the pool if given */
+function loadIncidents(pool, list) {
+    getJSON("api/incidents").then(function(incidents) {
+        list.innerHTML = "";
+
+        incidents.filter(function(incident) {
+            return pool === null || incident.pool === pool;
+        }).forEach(function(incident) {
+            var item = document.createElement("li");
+            item.className = incident.ended ? "ended" : "ongoing";
+
+            var text = (incidentNames[incident.kind] || incident.kind);
+
+            if (incident.pool && pool === null) {
+                text = incident.pool + ": " + text;
+            }
+
+            var started = new Date(incident.started);
+            text += " - " + started.toLocaleString();
+
+            if (incident.ended) {
+                var minutes = Math.round((Date.parse(incident.ended) -
+                                          started) / 60000);
+                text += ", for " + minutes + " minutes";
+            } else {
+                text += ", ongoing";
+            }
+
+            item.textContent = text;
+            list.appendChild(item);
+        });
+
+        if (list.children.length === 0) {
+            list.innerHTML = "<li class=\"none\">No incidents.</li>";
+        }
+    });
+}
+
+var svgNS = "http://www.w3.org/2000/svg";
+var chartWidth = 800;
+var chartHeight = 160;
+
+function svgElement(name, attributes) {
+    var element = document.createElementNS(svgNS, name);
+
+    for (var key in attributes) {
+        element.setAttribute(key, attributes[key]);
+    }
+
+    return element;
+}
+
+function chartX(samples, sample) {
+    var first = Date.parse(samples[0].time);
+    var last = Date.parse(samples[samples.length - 1].time);
+
+    if (last === first) {
+        return 0;
+    }
+
+    return (Date.parse(sample.time) - first) / (last - first) * chartWidth;
+}
+
+/* A line of how far ahead of the median the pool was, broken where the API
+   was down */
+function deviationChart(container, samples) {
+    container.innerHTML = "";
+
+    if (samples.length === 0) {
+        container.textContent = "No history yet.";
+        return;
+    }
+
+    var largest = 5;
+
+    samples.forEach(function(sample) {
+        largest = Math.max(largest, Math.abs(sample.deviation));
+    });
+
+    var svg = svgElement("svg", {
+        viewBox: "0 0 " + chartWidth + " " + chartHeight,
+        preserveAspectRatio: "none",
+    });
+
+    function y(deviation) {
+        return chartHeight / 2 - deviation / largest * (chartHeight / 2 - 4);
+    }
+
+    svg.appendChild(svgElement("line", {
+        x1: 0, x2: chartWidth, y1: y(0), y2: y(0), class: "axis",
+    }));
+
+    var path = "";
+    var drawing = false;
+
+    samples.forEach(function(sample) {
+        if (sample.height === 0) {
+            drawing = false;
+            return;
+        }
+
+        path += (drawing ? "L" : "M") + chartX(samples, sample).toFixed(1) +
+                "," + y(sample.deviation).toFixed(1);
+        drawing = true;
+    });
+
+    svg.appendChild(svgElement("path", {d: path, class: "deviation"}));
+
+    var label = svgElement("text", {x: 4, y: 14, class: "label"});
+    label.textContent = "±" + largest + " blocks";
+    svg.appendChild(label);
+
+    container.appendChild(svg);
+}
+
+/* A strip coloured by the pools status at each sample */
+function uptimeChart(container, samples) {
+    container.innerHTML = "";
+
+    if (samples.length === 0) {
+        return;
+    }
+
+    var svg = svgElement("svg", {
+        viewBox: "0 0 " + chartWidth + " 24",
+        preserveAspectRatio: "none",
+    });
+
+    samples.forEach(function(sample, index) {
+        var x = chartX(samples, sample);
+        var next = index + 1 < samples.length ?
+                   chartX(samples, samples[index + 1]) : chartWidth;
+
+        svg.appendChild(svgElement("rect", {
+            x: x, y: 0, width: Math.max(next - x, 1), height: 24,
+            class: statusClass(sample.status),
+        }));
+    });
+
+    container.appendChild(svg);
+}